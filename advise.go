@@ -0,0 +1,127 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//
+//	              Faculty of Arts, Charles University
+//	 This file is part of MARIADB-TSCL.
+//
+//	MARIADB-TSCL is free software: you can redistribute it and/or modify
+//	it under the terms of the GNU General Public License as published by
+//	the Free Software Foundation, either version 3 of the License, or
+//	(at your option) any later version.
+//
+//	MARIADB-TSCL is distributed in the hope that it will be useful,
+//	but WITHOUT ANY WARRANTY; without even the implied warranty of
+//	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//	GNU General Public License for more details.
+//
+//	You should have received a copy of the GNU General Public License
+//	along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/advisor"
+	"github.com/czcorpus/mariadb-tscl/cnf"
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/czcorpus/mariadb-tscl/reporting/sqlite"
+)
+
+// loadAdvisorInput reads actual status and variables of the monitored
+// server. In case historyDays > 0, status counters available
+// in the reporting database (or in the SQLite sink) are attached
+// as history (rules prefer them over the snapshot values).
+func loadAdvisorInput(ctx context.Context, conf *cnf.Conf, historyDays int) (*advisor.Input, error) {
+	mariadb, err := db.OpenDB(conf.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer mariadb.Close()
+	status, err := db.GetGlobalStatus(ctx, mariadb)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain global status: %w", err)
+	}
+	variables, err := db.GetGlobalVariables(ctx, mariadb)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain global variables: %w", err)
+	}
+	ans := &advisor.Input{
+		Status:    status,
+		Variables: variables,
+		Source:    "server status snapshot",
+	}
+	if historyDays <= 0 {
+		return ans, nil
+	}
+
+	history, numRecords, err := loadStatusHistory(ctx, conf, time.Now().AddDate(0, 0, -historyDays))
+	if err != nil {
+		return nil, err
+	}
+	if numRecords == 0 {
+		return nil, fmt.Errorf("no history records found for instance %s", conf.InstanceName)
+	}
+	ans.History = history
+	ans.Source = fmt.Sprintf(
+		"last %d days of history (%d records) combined with server status snapshot",
+		historyDays, numRecords,
+	)
+	return ans, nil
+}
+
+// loadStatusHistory reads aggregated status history from the reporting
+// database or (if it is not configured) from the SQLite sink
+func loadStatusHistory(ctx context.Context, conf *cnf.Conf, since time.Time) (map[string]int64, int, error) {
+	if conf.Reporting != nil {
+		pg, err := reporting.CreatePool(ctx, conf.Reporting)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer pg.Close()
+		if conf.Reporting.Layout == reporting.LayoutNarrow {
+			return reporting.LoadNarrowStatusHistory(ctx, pg, conf.InstanceName, since)
+		}
+		return reporting.LoadStatusHistory(ctx, pg, conf.InstanceName, since)
+
+	} else if conf.Sinks.SQLite != nil {
+		store, err := sqlite.OpenReadOnly(conf.Sinks.SQLite.Path)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer store.Close()
+		return store.LoadStatusHistory(ctx, conf.InstanceName, since)
+	}
+	return nil, 0, errors.New("history requested but neither reporting nor sinks.sqlite is configured")
+}
+
+func printAdvice(w io.Writer, input *advisor.Input, recs []advisor.Recommendation, format string) error {
+	switch format {
+	case "text":
+		fmt.Fprintf(w, "based on: %s\n\n", input.Source)
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, rec := range recs {
+			fmt.Fprintf(tw, "[%s]\t%s\t%s\n", rec.Priority, rec.Topic, rec.Finding)
+			if rec.Advice != "" {
+				fmt.Fprintf(tw, "\t\t-> %s\n", rec.Advice)
+			}
+		}
+		return tw.Flush()
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]any{
+			"source":          input.Source,
+			"recommendations": recs,
+		})
+	default:
+		return fmt.Errorf("unknown output format %s", format)
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package advisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Priority specifies how urgent a recommendation is
+type Priority int
+
+const (
+	PriorityOK Priority = iota
+	PriorityInfo
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+)
+
+func (p Priority) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityOK:
+		return "OK"
+	case PriorityInfo:
+		return "INFO"
+	case PriorityLow:
+		return "LOW"
+	case PriorityMedium:
+		return "MEDIUM"
+	case PriorityHigh:
+		return "HIGH"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
+
+// Input contains all the data the rules are evaluated against.
+// Keys of both maps are lowercased variable names.
+type Input struct {
+
+	// Status contains a snapshot of global status counters
+	// (i.e. values accumulated since the server start)
+	Status map[string]int64
+
+	// History contains status counters summed over a time range
+	// (gauges contain the maximum value over the range). It is
+	// optional and it may contain only a subset of the counters.
+	History map[string]int64
+
+	// Variables contains server's global variables
+	Variables map[string]string
+
+	// Source describes where the status values come from
+	// (e.g. "snapshot", "last 7 days")
+	Source string
+}
+
+// status provides values of the status counters. All the values
+// are taken either from the history (preferred) or from the snapshot
+// so a rule never combines values measured over different time ranges.
+func (input *Input) status(names ...string) ([]float64, bool) {
+	if ans, ok := lookupStatus(input.History, names); ok {
+		return ans, true
+	}
+	return lookupStatus(input.Status, names)
+}
+
+func lookupStatus(values map[string]int64, names []string) ([]float64, bool) {
+	ans := make([]float64, len(names))
+	for i, name := range names {
+		v, ok := values[name]
+		if !ok {
+			return nil, false
+		}
+		ans[i] = float64(v)
+	}
+	return ans, true
+}
+
+func (input *Input) variable(name string) (float64, bool) {
+	v, ok := input.Variables[name]
+	if !ok {
+		return 0, false
+	}
+	fv, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, false
+	}
+	return fv, true
+}
+
+// Recommendation is a result of a single rule evaluation
+type Recommendation struct {
+	Priority Priority `json:"priority"`
+	Topic    string   `json:"topic"`
+	Finding  string   `json:"finding"`
+	Advice   string   `json:"advice,omitempty"`
+}
+
+// rule evaluates a single heuristic. The second returned value
+// is false if the input does not contain data required by the rule.
+type rule func(input *Input) (Recommendation, bool)
+
+var rules = []rule{
+	bufferPoolHitRatio,
+	fullScanRatio,
+	tmpDiskTables,
+	tableCacheMisses,
+	abortedConnects,
+	connectionHeadroom,
+	slowQueries,
+	shortUptime,
+}
+
+// Evaluate applies all the rules to the input and returns
+// the recommendations sorted from the most urgent one.
+func Evaluate(input *Input) []Recommendation {
+	ans := make([]Recommendation, 0, len(rules))
+	for _, r := range rules {
+		if rec, ok := r(input); ok {
+			ans = append(ans, rec)
+		}
+	}
+	sort.SliceStable(ans, func(i, j int) bool {
+		return ans[i].Priority > ans[j].Priority
+	})
+	return ans
+}
+
+func bufferPoolHitRatio(input *Input) (Recommendation, bool) {
+	vals, ok := input.status("innodb_buffer_pool_reads", "innodb_buffer_pool_read_requests")
+	if !ok || vals[1] == 0 {
+		return Recommendation{}, false
+	}
+	ratio := 100 * (1 - vals[0]/vals[1])
+	ans := Recommendation{
+		Topic:   "InnoDB buffer pool",
+		Finding: fmt.Sprintf("buffer pool hit ratio is %.2f %%", ratio),
+	}
+	switch {
+	case ratio < 95:
+		ans.Priority = PriorityHigh
+	case ratio < 99:
+		ans.Priority = PriorityMedium
+	default:
+		ans.Priority = PriorityOK
+		return ans, true
+	}
+	ans.Advice = "consider increasing innodb_buffer_pool_size"
+	if size, ok := input.variable("innodb_buffer_pool_size"); ok {
+		ans.Advice += fmt.Sprintf(" (currently %s)", formatBytes(size))
+	}
+	return ans, true
+}
+
+func fullScanRatio(input *Input) (Recommendation, bool) {
+	vals, ok := input.status(
+		"handler_read_rnd_next",
+		"handler_read_first",
+		"handler_read_key",
+		"handler_read_next",
+		"handler_read_rnd",
+	)
+	if !ok {
+		return Recommendation{}, false
+	}
+	var total float64
+	for _, v := range vals {
+		total += v
+	}
+	if total == 0 {
+		return Recommendation{}, false
+	}
+	ratio := 100 * vals[0] / total
+	ans := Recommendation{
+		Topic:   "full table scans",
+		Finding: fmt.Sprintf("%.1f %% of row reads come from full table scans", ratio),
+	}
+	switch {
+	case ratio > 50:
+		ans.Priority = PriorityHigh
+	case ratio > 25:
+		ans.Priority = PriorityMedium
+	default:
+		ans.Priority = PriorityOK
+		return ans, true
+	}
+	ans.Advice = "enable the slow query log with log_queries_not_using_indexes " +
+		"and add indexes for the most frequent queries"
+	return ans, true
+}
+
+func tmpDiskTables(input *Input) (Recommendation, bool) {
+	vals, ok := input.status("created_tmp_disk_tables", "created_tmp_tables")
+	if !ok || vals[1] == 0 {
+		return Recommendation{}, false
+	}
+	ratio := 100 * vals[0] / vals[1]
+	ans := Recommendation{
+		Topic:   "temporary tables",
+		Finding: fmt.Sprintf("%.1f %% of temporary tables are created on disk", ratio),
+	}
+	switch {
+	case ratio > 50:
+		ans.Priority = PriorityHigh
+	case ratio > 25:
+		ans.Priority = PriorityMedium
+	default:
+		ans.Priority = PriorityOK
+		return ans, true
+	}
+	ans.Advice = "consider increasing tmp_table_size and max_heap_table_size " +
+		"(note that BLOB/TEXT columns always force on-disk tables)"
+	if size, ok := input.variable("tmp_table_size"); ok {
+		ans.Advice += fmt.Sprintf("; tmp_table_size is %s", formatBytes(size))
+	}
+	return ans, true
+}
+
+func tableCacheMisses(input *Input) (Recommendation, bool) {
+	vals, ok := input.status("table_open_cache_misses", "table_open_cache_hits")
+	if !ok || vals[0]+vals[1] == 0 {
+		return Recommendation{}, false
+	}
+	ratio := 100 * vals[0] / (vals[0] + vals[1])
+	ans := Recommendation{
+		Topic:   "table cache",
+		Finding: fmt.Sprintf("table open cache miss ratio is %.1f %%", ratio),
+	}
+	switch {
+	case ratio > 20:
+		ans.Priority = PriorityMedium
+	case ratio > 5:
+		ans.Priority = PriorityLow
+	default:
+		ans.Priority = PriorityOK
+		return ans, true
+	}
+	ans.Advice = "consider increasing table_open_cache"
+	if size, ok := input.variable("table_open_cache"); ok {
+		ans.Advice += fmt.Sprintf(" (currently %.0f)", size)
+	}
+	return ans, true
+}
+
+func abortedConnects(input *Input) (Recommendation, bool) {
+	vals, ok := input.status("aborted_connects", "connections")
+	if !ok || vals[1] == 0 {
+		return Recommendation{}, false
+	}
+	ratio := 100 * vals[0] / vals[1]
+	ans := Recommendation{
+		Topic:   "aborted connects",
+		Finding: fmt.Sprintf("%.2f %% of connection attempts failed", ratio),
+	}
+	switch {
+	case ratio > 5:
+		ans.Priority = PriorityHigh
+	case ratio > 1:
+		ans.Priority = PriorityMedium
+	default:
+		ans.Priority = PriorityOK
+		return ans, true
+	}
+	ans.Advice = "check client credentials, network stability and connect_timeout"
+	return ans, true
+}
+
+func connectionHeadroom(input *Input) (Recommendation, bool) {
+	vals, ok := input.status("max_used_connections")
+	if !ok {
+		return Recommendation{}, false
+	}
+	maxConn, ok := input.variable("max_connections")
+	if !ok || maxConn == 0 {
+		return Recommendation{}, false
+	}
+	ratio := 100 * vals[0] / maxConn
+	ans := Recommendation{
+		Topic: "connections",
+		Finding: fmt.Sprintf(
+			"max. used connections %.0f of %.0f allowed (%.1f %%)", vals[0], maxConn, ratio),
+	}
+	switch {
+	case ratio > 85:
+		ans.Priority = PriorityHigh
+	case ratio > 70:
+		ans.Priority = PriorityMedium
+	default:
+		ans.Priority = PriorityOK
+		return ans, true
+	}
+	ans.Advice = "increase max_connections or use connection pooling in the clients"
+	return ans, true
+}
+
+func slowQueries(input *Input) (Recommendation, bool) {
+	vals, ok := input.status("slow_queries", "com_select", "com_insert", "com_update", "com_delete")
+	if !ok {
+		return Recommendation{}, false
+	}
+	total := vals[1] + vals[2] + vals[3] + vals[4]
+	if total == 0 {
+		return Recommendation{}, false
+	}
+	ratio := 100 * vals[0] / total
+	ans := Recommendation{
+		Topic:   "slow queries",
+		Finding: fmt.Sprintf("%.2f %% of queries are slow", ratio),
+	}
+	switch {
+	case ratio > 5:
+		ans.Priority = PriorityHigh
+	case ratio > 1:
+		ans.Priority = PriorityMedium
+	default:
+		ans.Priority = PriorityOK
+		return ans, true
+	}
+	ans.Advice = "inspect the slow query log"
+	if lqt, ok := input.variable("long_query_time"); ok {
+		ans.Advice += fmt.Sprintf(" (long_query_time is %gs)", lqt)
+	}
+	return ans, true
+}
+
+func shortUptime(input *Input) (Recommendation, bool) {
+	vals, ok := input.status("uptime")
+	if !ok || vals[0] >= 86400 {
+		return Recommendation{}, false
+	}
+	return Recommendation{
+		Priority: PriorityInfo,
+		Topic:    "uptime",
+		Finding:  fmt.Sprintf("server is running for %.1f hours only", vals[0]/3600),
+		Advice:   "counters may not be representative, run the advisor later",
+	}, true
+}
+
+func formatBytes(v float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
@@ -0,0 +1,199 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package advisor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      rule
+		status    map[string]int64
+		variables map[string]string
+		applies   bool
+		priority  Priority
+	}{
+		{
+			name:     "buffer pool hit ratio is fine",
+			rule:     bufferPoolHitRatio,
+			status:   map[string]int64{"innodb_buffer_pool_reads": 5, "innodb_buffer_pool_read_requests": 1000},
+			applies:  true,
+			priority: PriorityOK,
+		},
+		{
+			name:     "buffer pool hit ratio is low",
+			rule:     bufferPoolHitRatio,
+			status:   map[string]int64{"innodb_buffer_pool_reads": 20, "innodb_buffer_pool_read_requests": 1000},
+			applies:  true,
+			priority: PriorityMedium,
+		},
+		{
+			name:    "buffer pool without requests",
+			rule:    bufferPoolHitRatio,
+			status:  map[string]int64{"innodb_buffer_pool_reads": 0, "innodb_buffer_pool_read_requests": 0},
+			applies: false,
+		},
+		{
+			name: "mostly full table scans",
+			rule: fullScanRatio,
+			status: map[string]int64{
+				"handler_read_rnd_next": 600,
+				"handler_read_first":    100,
+				"handler_read_key":      100,
+				"handler_read_next":     100,
+				"handler_read_rnd":      100,
+			},
+			applies:  true,
+			priority: PriorityHigh,
+		},
+		{
+			name:     "temporary tables on disk",
+			rule:     tmpDiskTables,
+			status:   map[string]int64{"created_tmp_disk_tables": 30, "created_tmp_tables": 100},
+			applies:  true,
+			priority: PriorityMedium,
+		},
+		{
+			name:     "table cache misses",
+			rule:     tableCacheMisses,
+			status:   map[string]int64{"table_open_cache_misses": 10, "table_open_cache_hits": 90},
+			applies:  true,
+			priority: PriorityLow,
+		},
+		{
+			name:     "aborted connects",
+			rule:     abortedConnects,
+			status:   map[string]int64{"aborted_connects": 10, "connections": 100},
+			applies:  true,
+			priority: PriorityHigh,
+		},
+		{
+			name:      "connection headroom",
+			rule:      connectionHeadroom,
+			status:    map[string]int64{"max_used_connections": 90},
+			variables: map[string]string{"max_connections": "100"},
+			applies:   true,
+			priority:  PriorityHigh,
+		},
+		{
+			name:    "connection headroom without variables",
+			rule:    connectionHeadroom,
+			status:  map[string]int64{"max_used_connections": 90},
+			applies: false,
+		},
+		{
+			name: "slow queries",
+			rule: slowQueries,
+			status: map[string]int64{
+				"slow_queries": 2,
+				"com_select":   70,
+				"com_insert":   10,
+				"com_update":   10,
+				"com_delete":   10,
+			},
+			applies:  true,
+			priority: PriorityMedium,
+		},
+		{
+			name:     "short uptime",
+			rule:     shortUptime,
+			status:   map[string]int64{"uptime": 3600},
+			applies:  true,
+			priority: PriorityInfo,
+		},
+		{
+			name:    "long uptime",
+			rule:    shortUptime,
+			status:  map[string]int64{"uptime": 7 * 86400},
+			applies: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, ok := tt.rule(&Input{Status: tt.status, Variables: tt.variables})
+			assert.Equal(t, tt.applies, ok)
+			if tt.applies {
+				assert.Equal(t, tt.priority, rec.Priority)
+			}
+		})
+	}
+}
+
+func TestStatusDoesNotMixHistoryAndSnapshot(t *testing.T) {
+	input := &Input{
+		Status: map[string]int64{
+			"aborted_connects": 1,
+			"connections":      1000,
+			"slow_queries":     1,
+			"com_select":       1000,
+			"com_insert":       0,
+			"com_update":       0,
+			"com_delete":       0,
+		},
+		History: map[string]int64{
+			"aborted_connects": 500,
+			"slow_queries":     10,
+			"com_select":       100,
+			"com_insert":       0,
+			"com_update":       0,
+			"com_delete":       0,
+		},
+	}
+	tests := []struct {
+		name     string
+		names    []string
+		expected []float64
+	}{
+		{
+			name:     "all values in history",
+			names:    []string{"slow_queries", "com_select"},
+			expected: []float64{10, 100},
+		},
+		{
+			name:     "partial history falls back to snapshot",
+			names:    []string{"aborted_connects", "connections"},
+			expected: []float64{1, 1000},
+		},
+		{
+			name:     "snapshot only",
+			names:    []string{"connections"},
+			expected: []float64{1000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vals, ok := input.status(tt.names...)
+			assert.True(t, ok)
+			assert.Equal(t, tt.expected, vals)
+		})
+	}
+	_, ok := input.status("uptime")
+	assert.False(t, ok)
+
+	priorities := make(map[string]Priority)
+	for _, rec := range Evaluate(input) {
+		priorities[rec.Topic] = rec.Priority
+	}
+	assert.Equal(t, PriorityOK, priorities["aborted connects"])
+	assert.Equal(t, PriorityHigh, priorities["slow queries"])
+}
@@ -0,0 +1,213 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//
+//	              Faculty of Arts, Charles University
+//	 This file is part of MARIADB-TSCL.
+//
+//	MARIADB-TSCL is free software: you can redistribute it and/or modify
+//	it under the terms of the GNU General Public License as published by
+//	the Free Software Foundation, either version 3 of the License, or
+//	(at your option) any later version.
+//
+//	MARIADB-TSCL is distributed in the hope that it will be useful,
+//	but WITHOUT ANY WARRANTY; without even the implied warranty of
+//	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//	GNU General Public License for more details.
+//
+//	You should have received a copy of the GNU General Public License
+//	along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/cnf"
+	"github.com/czcorpus/mariadb-tscl/collector"
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/czcorpus/mariadb-tscl/reporting/filesink"
+	"github.com/czcorpus/mariadb-tscl/reporting/graphite"
+	"github.com/czcorpus/mariadb-tscl/reporting/influx"
+	"github.com/czcorpus/mariadb-tscl/reporting/otlp"
+	"github.com/czcorpus/mariadb-tscl/reporting/sqlite"
+	"github.com/czcorpus/mariadb-tscl/reporting/textfile"
+)
+
+// checkTimeout limits the time all the checks may take
+// (e.g. in case a database host is unreachable)
+const checkTimeout = 30 * time.Second
+
+var errCheckSkipped = errors.New("skipped due to previous failure")
+
+type checkResult struct {
+	Name string
+	Err  error
+}
+
+func (cr checkResult) Passed() bool {
+	return cr.Err == nil
+}
+
+// runChecks loads and validates the configuration and tests whether
+// the monitored database and all the configured outputs are usable
+// with the configured credentials.
+func runChecks(ctx context.Context, confPath string) []checkResult {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	ans := make([]checkResult, 0, 8)
+	add := func(name string, err error) bool {
+		ans = append(ans, checkResult{Name: name, Err: err})
+		return err == nil
+	}
+
+	conf, err := cnf.LoadConfig(confPath)
+	if !add("configuration", err) {
+		return ans
+	}
+
+	mariadb, err := db.OpenDB(conf.DB)
+	if err == nil {
+		defer mariadb.Close()
+		err = mariadb.PingContext(ctx)
+	}
+	connOK := add("MariaDB connection", err)
+	env := &collector.Env{
+		DB:              mariadb,
+		InstanceName:    conf.InstanceName,
+		DefaultInterval: conf.CheckInterval.Duration(),
+	}
+	collectors := make([]collector.Collector, 0, len(conf.Collectors))
+	for _, name := range slices.Sorted(maps.Keys(conf.Collectors)) {
+		coll, err := collector.New(name, env, conf.Collectors[name])
+		if err != nil {
+			add(fmt.Sprintf("collector %s", name), err)
+			continue
+		}
+		collectors = append(collectors, coll)
+		checkName := fmt.Sprintf("collector %s queries", name)
+		if connOK {
+			add(checkName, checkCollectorQueries(ctx, coll))
+
+		} else {
+			add(checkName, errCheckSkipped)
+		}
+	}
+
+	if conf.Reporting != nil {
+		checkTimescaleDB(ctx, conf, collectors, add)
+	}
+	if conf.Sinks.Influx != nil {
+		add("InfluxDB line protocol output", influx.CheckOutput(ctx, conf.Sinks.Influx))
+	}
+	if conf.Sinks.File != nil {
+		add("file output", filesink.CheckDir(conf.Sinks.File))
+	}
+	if conf.Sinks.Textfile != nil {
+		add("node_exporter textfile output", textfile.CheckPath(conf.Sinks.Textfile))
+	}
+	if conf.Sinks.Graphite != nil {
+		add(conf.Sinks.Graphite.Protocol+" output", graphite.CheckAddress(ctx, conf.Sinks.Graphite))
+	}
+	if conf.Sinks.OTLP != nil {
+		add("OTLP metrics output", otlp.CheckEndpoint(ctx, conf.Sinks.OTLP))
+	}
+	if conf.Sinks.SQLite != nil {
+		add("SQLite output", sqlite.CheckDB(conf.Sinks.SQLite))
+	}
+	return ans
+}
+
+// checkTimescaleDB tests the connection to the reporting database
+// and structure of all the tables records are written to
+func checkTimescaleDB(
+	ctx context.Context,
+	conf *cnf.Conf,
+	collectors []collector.Collector,
+	add func(name string, err error) bool,
+) {
+	pg, err := reporting.CreatePool(ctx, conf.Reporting)
+	if err == nil {
+		defer pg.Close()
+		err = pg.Ping(ctx)
+	}
+	connOK := add("TimescaleDB connection", err)
+	if conf.Reporting.Layout == reporting.LayoutNarrow {
+		name := "TimescaleDB narrow layout tables"
+		if connOK {
+			add(name, reporting.CheckNarrowTables(ctx, pg))
+
+		} else {
+			add(name, errCheckSkipped)
+		}
+		return
+	}
+	tables := make(map[string][]string)
+	for _, coll := range collectors {
+		for _, table := range coll.Tables() {
+			tables[table] = reporting.ExpectedColumns(table)
+		}
+	}
+	if conf.SelfMonitoring != nil {
+		tables[reporting.MariaDBTSCLSelfTable] = reporting.ExpectedColumns(reporting.MariaDBTSCLSelfTable)
+	}
+	if len(conf.Labels) > 0 {
+		for table := range tables {
+			tables[table] = append(tables[table], reporting.LabelsColumnName)
+		}
+	}
+	for _, table := range slices.Sorted(maps.Keys(tables)) {
+		name := fmt.Sprintf("TimescaleDB table %s", table)
+		if connOK {
+			add(name, reporting.CheckTable(ctx, pg, table, tables[table]))
+
+		} else {
+			add(name, errCheckSkipped)
+		}
+	}
+}
+
+// checkCollectorQueries runs a single collection to find out whether
+// the monitoring user is allowed to run the collector's queries
+func checkCollectorQueries(ctx context.Context, coll collector.Collector) error {
+	if _, err := coll.Collect(ctx); err != nil {
+		if privs := coll.RequiredPrivileges(); len(privs) > 0 {
+			return fmt.Errorf("%w (required privileges: %s)", err, strings.Join(privs, ", "))
+		}
+		return err
+	}
+	return nil
+}
+
+// printCheckReport writes a human readable report and returns
+// true if all the checks passed
+func printCheckReport(w io.Writer, results []checkResult) bool {
+	allPassed := true
+	for _, res := range results {
+		if res.Passed() {
+			fmt.Fprintf(w, "[ OK ] %s\n", res.Name)
+
+		} else if errors.Is(res.Err, errCheckSkipped) {
+			fmt.Fprintf(w, "[SKIP] %s: %s\n", res.Name, res.Err)
+			allPassed = false
+
+		} else {
+			fmt.Fprintf(w, "[FAIL] %s: %s\n", res.Name, res.Err)
+			allPassed = false
+		}
+	}
+	if allPassed {
+		fmt.Fprintln(w, "\nall checks passed")
+
+	} else {
+		fmt.Fprintln(w, "\nsome checks failed")
+	}
+	return allPassed
+}
@@ -20,44 +20,184 @@ package cnf
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/czcorpus/cnc-gokit/logging"
+	"github.com/czcorpus/mariadb-tscl/collector"
 	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/general"
 	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/czcorpus/mariadb-tscl/selfmon"
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	dfltCheckInterval = general.Duration(10 * time.Second)
+	dfltTimezone      = "Europe/Prague"
+
+	dfltShutdownTimeout = general.Duration(10 * time.Second)
+
+	dfltCollector = "global_status"
+)
+
 // Conf is a global configuration of the app
 type Conf struct {
-	Logging       logging.LoggingConf `json:"logging"`
-	InstanceName  string              `json:"instanceName"`
-	CheckInterval time.Duration       `json:"checkInterval"`
-	DB            *db.Conf            `json:"db"`
-	Reporting     *reporting.Conf     `json:"reporting"`
+	Logging      logging.LoggingConf `json:"logging"`
+	InstanceName string              `json:"instanceName"`
+
+	// CheckInterval specifies how often the status is collected.
+	// Both Go duration strings ("10s") and numbers of seconds are accepted.
+	CheckInterval general.Duration `json:"checkInterval"`
+
+	// Labels are attached to all the records as additional tags
+	// (e.g. {"environment": "production", "role": "primary"}) so
+	// records can be filtered without relying on instance names
+	Labels map[string]string `json:"labels"`
+
+	// TimeZone is an IANA time zone name (e.g. "UTC" or "Local")
+	// used for timestamps of the reported records. By default,
+	// Europe/Prague is used (which was the only option before).
+	TimeZone string `json:"timezone"`
+
+	// ShutdownTimeout limits how long the daemon waits for pending
+	// reporting writes when stopping (or when the reporting
+	// configuration is reloaded)
+	ShutdownTimeout general.Duration `json:"shutdownTimeout"`
+
+	DB        *db.Conf        `json:"db"`
+	Reporting *reporting.Conf `json:"reporting"`
+
+	// Collectors maps names of enabled collectors to their
+	// configuration. By default, only global_status is enabled.
+	Collectors map[string]json.RawMessage `json:"collectors"`
+
+	// Schedule configures timing of collectors
+	Schedule collector.ScheduleConf `json:"schedule"`
+
+	// Sinks configures additional outputs of collected records
+	Sinks SinksConf `json:"sinks"`
+
+	// SelfMonitoring enables reporting of MariaDB-TSCL's own
+	// metrics (disabled if not configured)
+	SelfMonitoring *selfmon.Conf `json:"selfMonitoring"`
+
+	location *time.Location
+}
+
+// GetLocation provides a location configured via the `timezone` item.
+// The value is available only after ValidateAndDefaults is called.
+func (conf *Conf) GetLocation() *time.Location {
+	if conf.location == nil {
+		return time.Local
+	}
+	return conf.location
 }
 
-func (conf *Conf) GetLocation() *time.Location { // TODO
-	loc, err := time.LoadLocation("Europe/Prague")
+// ValidateAndDefaults checks the configuration and sets default
+// values for missing optional items.
+func (conf *Conf) ValidateAndDefaults() error {
+	if conf.DB == nil {
+		return errors.New("db not configured")
+	}
+	if err := conf.DB.Validate("db"); err != nil {
+		return err
+	}
+	if err := conf.Reporting.ValidateAndDefaults(); err != nil {
+		return err
+	}
+	if err := conf.Sinks.ValidateAndDefaults(); err != nil {
+		return err
+	}
+	if conf.Reporting == nil && conf.Sinks.IsEmpty() {
+		log.Warn().Msg("reporting not configured, MariaDB-TSCL will be writing reporting records to log")
+	}
+	if err := conf.SelfMonitoring.ValidateAndDefaults(); err != nil {
+		return err
+	}
+	if conf.InstanceName == "" {
+		conf.InstanceName = conf.DB.Address()
+		log.Warn().Msgf("missing instanceName, setting %s", conf.InstanceName)
+	}
+	if err := reporting.ValidateLabels(conf.Labels); err != nil {
+		return fmt.Errorf("invalid labels: %w", err)
+	}
+	if conf.Reporting != nil && conf.Reporting.ApplicationName == "" {
+		conf.Reporting.ApplicationName = "mariadb-tscl/" + conf.InstanceName
+	}
+	if conf.CheckInterval == 0 {
+		conf.CheckInterval = dfltCheckInterval
+		log.Warn().Msgf("missing checkInterval, setting %s", conf.CheckInterval)
+
+	} else if conf.CheckInterval < 0 {
+		return fmt.Errorf("invalid checkInterval %s", conf.CheckInterval)
+	}
+	if conf.ShutdownTimeout == 0 {
+		conf.ShutdownTimeout = dfltShutdownTimeout
+
+	} else if conf.ShutdownTimeout < 0 {
+		return fmt.Errorf("invalid shutdownTimeout %s", conf.ShutdownTimeout)
+	}
+	if len(conf.Collectors) == 0 {
+		conf.Collectors = map[string]json.RawMessage{dfltCollector: nil}
+	}
+	for name := range conf.Collectors {
+		if !collector.IsRegistered(name) {
+			return fmt.Errorf(
+				"unknown collector %s (available: %s)",
+				name, strings.Join(collector.Names(), ", "),
+			)
+		}
+	}
+	if err := conf.Schedule.ValidateAndDefaults(); err != nil {
+		return err
+	}
+	if conf.TimeZone == "" {
+		conf.TimeZone = dfltTimezone
+	}
+	loc, err := time.LoadLocation(conf.TimeZone)
 	if err != nil {
-		log.Fatal().Msg("failed to initialize location")
+		return fmt.Errorf("invalid timezone %s: %w", conf.TimeZone, err)
 	}
-	return loc
+	conf.location = loc
+	return nil
 }
 
-func LoadConfig(path string) *Conf {
+// LoadConfig reads the configuration file, applies overrides from
+// environment variables, resolves credentials stored in separate files
+// and validates the result.
+func LoadConfig(path string) (*Conf, error) {
 	if path == "" {
-		log.Fatal().Msg("Cannot load config - path not specified")
+		return nil, errors.New("cannot load config - path not specified")
 	}
 	rawData, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Cannot load config")
+		return nil, fmt.Errorf("cannot load config: %w", err)
 	}
 	var conf Conf
-	err = json.Unmarshal(rawData, &conf)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Cannot load config")
+	if err := json.Unmarshal(rawData, &conf); err != nil {
+		return nil, fmt.Errorf("cannot load config: %w", err)
+	}
+	if err := applyEnvOverrides(EnvPrefix, reflect.ValueOf(&conf).Elem()); err != nil {
+		return nil, fmt.Errorf("cannot load config: %w", err)
+	}
+	if conf.DB != nil {
+		if err := conf.DB.ResolveCredentials(); err != nil {
+			return nil, fmt.Errorf("cannot load config: %w", err)
+		}
+	}
+	if err := conf.Reporting.ResolveCredentials(); err != nil {
+		return nil, fmt.Errorf("cannot load config: %w", err)
+	}
+	if err := conf.Sinks.ResolveCredentials(); err != nil {
+		return nil, fmt.Errorf("cannot load config: %w", err)
+	}
+	if err := conf.ValidateAndDefaults(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
 	}
-	return &conf
+	return &conf, nil
 }
@@ -0,0 +1,234 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package cnf
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// EnvPrefix is a prefix of all the environment variables
+// overriding configuration values
+const EnvPrefix = "MARIADB_TSCL"
+
+// envVarName converts a JSON key (camelCase) to a part
+// of an environment variable name (UPPER_SNAKE_CASE)
+func envVarName(jsonKey string) string {
+	var ans strings.Builder
+	runes := []rune(jsonKey)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+			ans.WriteRune('_')
+		}
+		ans.WriteRune(unicode.ToUpper(r))
+	}
+	return ans.String()
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// envFieldName provides a name of the environment variable
+// matching the field or an empty string if the field cannot
+// be overridden
+func envFieldName(prefix string, field reflect.StructField) string {
+	jsonName := jsonFieldName(field)
+	if jsonName == "" {
+		return ""
+	}
+	return prefix + "_" + envVarName(jsonName)
+}
+
+// envMapEntries provides map keys (lowercased) and values of all
+// the environment variables starting with the prefix
+// (e.g. MARIADB_TSCL_LABELS_ROLE=primary provides role => primary
+// for the prefix MARIADB_TSCL_LABELS).
+func envMapEntries(prefix string) map[string]string {
+	ans := make(map[string]string)
+	for _, item := range os.Environ() {
+		name, value, _ := strings.Cut(item, "=")
+		key, ok := strings.CutPrefix(name, prefix+"_")
+		if ok && key != "" {
+			ans[strings.ToLower(key)] = value
+		}
+	}
+	return ans
+}
+
+// hasEnvOverride tests whether there is an environment variable
+// matching any field (even a nested one) of the type t
+func hasEnvOverride(prefix string, t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			if hasEnvOverride(prefix, field.Type) {
+				return true
+			}
+			continue
+		}
+		name := envFieldName(prefix, field)
+		if name == "" {
+			continue
+		}
+		if _, ok := os.LookupEnv(name); ok {
+			return true
+		}
+		if field.Type.Kind() == reflect.Map && len(envMapEntries(name)) > 0 {
+			return true
+		}
+		if hasEnvOverride(name, field.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+func setFromEnv(name, value string, v reflect.Value) error {
+	if v.Kind() == reflect.String {
+		v.SetString(value)
+		return nil
+	}
+	// values are expected to be JSON-encoded (numbers, booleans, arrays...)
+	// but for convenience, also unquoted strings are accepted for types
+	// with custom unmarshaling (e.g. durations)
+	if err := json.Unmarshal([]byte(value), v.Addr().Interface()); err != nil {
+		quoted, _ := json.Marshal(value)
+		if err2 := json.Unmarshal(quoted, v.Addr().Interface()); err2 != nil {
+			return fmt.Errorf("invalid value of %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// applyMapOverrides sets map entries from environment variables
+// named prefix_KEY. An existing key is matched in the same way as
+// a field name (e.g. MARIADB_TSCL_COLLECTORS_GLOBAL_STATUS matches
+// global_status), other keys are added lowercased.
+func applyMapOverrides(prefix string, v reflect.Value) error {
+	if v.Type().Key().Kind() != reflect.String {
+		return nil
+	}
+	entries := envMapEntries(prefix)
+	if len(entries) == 0 {
+		return nil
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	keys := make(map[string]reflect.Value)
+	for _, k := range v.MapKeys() {
+		keys[strings.ToLower(envVarName(k.String()))] = k
+	}
+	for key, value := range entries {
+		mk, ok := keys[key]
+		if !ok {
+			mk = reflect.ValueOf(key).Convert(v.Type().Key())
+		}
+		item := reflect.New(v.Type().Elem()).Elem()
+		if err := setFromEnv(prefix+"_"+strings.ToUpper(key), value, item); err != nil {
+			return err
+		}
+		v.SetMapIndex(mk, item)
+	}
+	return nil
+}
+
+// applyEnvOverrides walks through the configuration structure
+// and replaces values for which a matching environment variable
+// is set. Variable names are derived from JSON keys, e.g.
+// `db.password` can be overridden by MARIADB_TSCL_DB_PASSWORD.
+// Map entries can be set individually, e.g. `labels.role` by
+// MARIADB_TSCL_LABELS_ROLE. Missing sections are created only
+// if a variable matching any of their fields is set.
+func applyEnvOverrides(prefix string, v reflect.Value) error {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			if !hasEnvOverride(prefix, v.Type()) {
+				return nil
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			if err := applyEnvOverrides(prefix, fv); err != nil {
+				return err
+			}
+			continue
+		}
+		name := envFieldName(prefix, field)
+		if name == "" {
+			continue
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			if err := setFromEnv(name, value, fv); err != nil {
+				return err
+			}
+			continue
+		}
+		ft := field.Type
+		if ft.Kind() == reflect.Map {
+			if err := applyMapOverrides(name, fv); err != nil {
+				return err
+			}
+			continue
+		}
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct {
+			if err := applyEnvOverrides(name, fv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
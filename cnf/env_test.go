@@ -0,0 +1,126 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package cnf
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSinkConf struct {
+	Path string `json:"path"`
+}
+
+type testSinksConf struct {
+	File *testSinkConf `json:"file"`
+}
+
+type testConf struct {
+	InstanceName string                     `json:"instanceName"`
+	Port         int                        `json:"port"`
+	Sinks        testSinksConf              `json:"sinks"`
+	Labels       map[string]string          `json:"labels"`
+	Collectors   map[string]json.RawMessage `json:"collectors"`
+}
+
+func TestEnvVarName(t *testing.T) {
+	tests := []struct {
+		jsonKey  string
+		expected string
+	}{
+		{"password", "PASSWORD"},
+		{"instanceName", "INSTANCE_NAME"},
+		{"passwordFile", "PASSWORD_FILE"},
+		{"maxIdleConns", "MAX_IDLE_CONNS"},
+		{"global_status", "GLOBAL_STATUS"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, envVarName(tt.jsonKey))
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		conf     testConf
+		expected testConf
+	}{
+		{
+			name:     "scalar values",
+			env:      map[string]string{"TEST_INSTANCE_NAME": "db1", "TEST_PORT": "3307"},
+			expected: testConf{InstanceName: "db1", Port: 3307},
+		},
+		{
+			name: "nested section is created for a matching field",
+			env:  map[string]string{"TEST_SINKS_FILE_PATH": "/tmp/out"},
+			expected: testConf{
+				Sinks: testSinksConf{File: &testSinkConf{Path: "/tmp/out"}},
+			},
+		},
+		{
+			name:     "nested section is not created for an unknown field",
+			env:      map[string]string{"TEST_SINKS_FILE_FORMAT": "csv", "TEST_SINKS_FILEX": "x"},
+			expected: testConf{},
+		},
+		{
+			name:     "longer names do not match",
+			env:      map[string]string{"TEST_PORT_NUMBER": "1"},
+			conf:     testConf{Port: 3306},
+			expected: testConf{Port: 3306},
+		},
+		{
+			name: "map entries are added",
+			env:  map[string]string{"TEST_LABELS_ROLE": "primary"},
+			conf: testConf{Labels: map[string]string{"environment": "production"}},
+			expected: testConf{
+				Labels: map[string]string{"environment": "production", "role": "primary"},
+			},
+		},
+		{
+			name: "existing map entries are replaced",
+			env:  map[string]string{"TEST_COLLECTORS_GLOBAL_STATUS": `{"interval":"5s"}`},
+			conf: testConf{Collectors: map[string]json.RawMessage{"global_status": nil}},
+			expected: testConf{
+				Collectors: map[string]json.RawMessage{
+					"global_status": json.RawMessage(`{"interval":"5s"}`),
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			conf := tt.conf
+			assert.NoError(t, applyEnvOverrides("TEST", reflect.ValueOf(&conf).Elem()))
+			assert.Equal(t, tt.expected, conf)
+		})
+	}
+}
+
+func TestApplyEnvOverridesInvalidValue(t *testing.T) {
+	t.Setenv("TEST_PORT", "abc")
+	var conf testConf
+	assert.Error(t, applyEnvOverrides("TEST", reflect.ValueOf(&conf).Elem()))
+}
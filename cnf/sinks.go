@@ -0,0 +1,69 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package cnf
+
+import (
+	"github.com/czcorpus/mariadb-tscl/reporting/filesink"
+	"github.com/czcorpus/mariadb-tscl/reporting/graphite"
+	"github.com/czcorpus/mariadb-tscl/reporting/influx"
+	"github.com/czcorpus/mariadb-tscl/reporting/otlp"
+	"github.com/czcorpus/mariadb-tscl/reporting/sqlite"
+	"github.com/czcorpus/mariadb-tscl/reporting/textfile"
+)
+
+// SinksConf configures outputs of collected records other than
+// TimescaleDB (which is configured in the `reporting` section).
+// Any combination of outputs can be used.
+type SinksConf struct {
+	Influx   *influx.Conf   `json:"influx"`
+	File     *filesink.Conf `json:"file"`
+	Textfile *textfile.Conf `json:"textfile"`
+	Graphite *graphite.Conf `json:"graphite"`
+	OTLP     *otlp.Conf     `json:"otlp"`
+	SQLite   *sqlite.Conf   `json:"sqlite"`
+}
+
+// IsEmpty tests whether no output is configured
+func (conf *SinksConf) IsEmpty() bool {
+	return conf.Influx == nil && conf.File == nil && conf.Textfile == nil &&
+		conf.Graphite == nil && conf.OTLP == nil && conf.SQLite == nil
+}
+
+func (conf *SinksConf) ResolveCredentials() error {
+	return conf.Influx.ResolveCredentials()
+}
+
+func (conf *SinksConf) ValidateAndDefaults() error {
+	if err := conf.Influx.ValidateAndDefaults(); err != nil {
+		return err
+	}
+	if err := conf.File.ValidateAndDefaults(); err != nil {
+		return err
+	}
+	if err := conf.Textfile.ValidateAndDefaults(); err != nil {
+		return err
+	}
+	if err := conf.Graphite.ValidateAndDefaults(); err != nil {
+		return err
+	}
+	if err := conf.OTLP.ValidateAndDefaults(); err != nil {
+		return err
+	}
+	return conf.SQLite.ValidateAndDefaults()
+}
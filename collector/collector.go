@@ -0,0 +1,153 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package collector defines an interface of data sources used to
+// obtain information from the monitored database. Implementations
+// live in their own packages and register themselves (typically
+// in their init functions) via Register.
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/reporting"
+)
+
+// Collector obtains a specific kind of data from the monitored
+// database. Each collector is scheduled independently.
+type Collector interface {
+
+	// Name is a unique name of the collector. It is also used
+	// as a key of the collector's configuration.
+	Name() string
+
+	// Interval specifies how often Collect should be called
+	Interval() time.Duration
+
+	// Tables lists all the reporting tables the collector writes to
+	Tables() []string
+
+	// RequiredPrivileges lists MariaDB privileges the monitoring
+	// user needs for the collector's queries (e.g. PROCESS)
+	RequiredPrivileges() []string
+
+	// Collect obtains the current data and converts them into
+	// records. A collector may return no records (e.g. if it
+	// needs a baseline first).
+	Collect(ctx context.Context) ([]reporting.Timescalable, error)
+}
+
+// Env contains resources shared by all the collectors. The service
+// may update the values (e.g. after a configuration reload) but
+// only while no collector is running.
+type Env struct {
+	DB              *sql.DB
+	InstanceName    string
+	DefaultInterval time.Duration
+
+	// Clock provides the current time. If nil, the system
+	// time is used (other clocks are used e.g. for replaying
+	// recorded data).
+	Clock func() time.Time
+}
+
+// Now provides the current time according to the environment's clock.
+// Collectors should use it for timestamps of their records.
+func (env *Env) Now() time.Time {
+	if env.Clock != nil {
+		return env.Clock()
+	}
+	return time.Now()
+}
+
+// Collection identifies a single run of a collector
+type Collection struct {
+	Collector string
+	Time      time.Time
+}
+
+type collectionKey struct{}
+
+// WithCollection attaches information about a collector run
+// to the context passed to Collect
+func WithCollection(ctx context.Context, coll Collection) context.Context {
+	return context.WithValue(ctx, collectionKey{}, coll)
+}
+
+// CollectionFromContext provides information about a collector
+// run the context belongs to (if any)
+func CollectionFromContext(ctx context.Context) (Collection, bool) {
+	coll, ok := ctx.Value(collectionKey{}).(Collection)
+	return coll, ok
+}
+
+// Factory creates a collector out of its raw JSON configuration
+type Factory func(env *Env, conf json.RawMessage) (Collector, error)
+
+var (
+	registry   = make(map[string]Factory)
+	registryMu sync.RWMutex
+)
+
+// Register makes a collector available under the provided name.
+// It panics if the name is already registered.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("collector %s already registered", name))
+	}
+	registry[name] = factory
+}
+
+// IsRegistered tests whether a collector of the provided name exists
+func IsRegistered(name string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	_, ok := registry[name]
+	return ok
+}
+
+// Names provides sorted names of all the registered collectors
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return slices.Sorted(maps.Keys(registry))
+}
+
+// New creates a registered collector
+func New(name string, env *Env, conf json.RawMessage) (Collector, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown collector %s", name)
+	}
+	coll, err := factory(env, conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create collector %s: %w", name, err)
+	}
+	return coll, nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/general"
+)
+
+const (
+	// TickAlignmentWallClock places collector ticks on wall-clock
+	// multiples of their intervals
+	TickAlignmentWallClock = "wall-clock"
+
+	// TickAlignmentNone places collector ticks relative
+	// to the start of the collector
+	TickAlignmentNone = "none"
+)
+
+// ScheduleConf configures timing of all the collectors
+type ScheduleConf struct {
+
+	// TickAlignment is either "wall-clock" (default) or "none"
+	TickAlignment string `json:"tickAlignment"`
+
+	// StartJitter is a maximum random delay of collectors' start.
+	// It helps to spread load when multiple instances start
+	// at the same time.
+	StartJitter general.Duration `json:"startJitter"`
+}
+
+func (conf *ScheduleConf) ValidateAndDefaults() error {
+	if conf.TickAlignment == "" {
+		conf.TickAlignment = TickAlignmentWallClock
+
+	} else if conf.TickAlignment != TickAlignmentWallClock && conf.TickAlignment != TickAlignmentNone {
+		return fmt.Errorf("invalid schedule.tickAlignment %s", conf.TickAlignment)
+	}
+	if conf.StartJitter < 0 {
+		return fmt.Errorf("invalid schedule.startJitter %s", conf.StartJitter)
+	}
+	return nil
+}
+
+// BaseConf contains configuration items common to all
+// the collectors. Collectors are expected to embed it
+// in their own configuration.
+type BaseConf struct {
+
+	// Interval specifies how often the collector runs. If omitted,
+	// the global `checkInterval` is used.
+	Interval general.Duration `json:"interval"`
+}
+
+// GetInterval provides the configured interval or the default one
+func (conf *BaseConf) GetInterval(env *Env) time.Duration {
+	if conf.Interval > 0 {
+		return conf.Interval.Duration()
+	}
+	return env.DefaultInterval
+}
+
+func (conf *BaseConf) Validate(name string) error {
+	if conf.Interval < 0 {
+		return fmt.Errorf("invalid collectors.%s.interval %s", name, conf.Interval)
+	}
+	return nil
+}
+
+// ParseConf decodes the raw configuration of a collector.
+// Unknown items are reported as errors. An empty configuration
+// leaves `v` untouched.
+func ParseConf(raw json.RawMessage, v any) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package globalstatus provides a collector of selected global status
+// variables. Cumulative counters are reported as differences
+// since the previous collection.
+package globalstatus
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/collector"
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/rs/zerolog/log"
+)
+
+// Name is the name of the collector
+const Name = "global_status"
+
+type Conf struct {
+	collector.BaseConf
+}
+
+type Collector struct {
+	env  *collector.Env
+	conf Conf
+
+	// prevStatus is a baseline for calculating differences
+	// of cumulative counters
+	prevStatus *db.Status
+
+	// prevTime is the time the baseline was obtained
+	prevTime time.Time
+
+	// serverStart is the time the cumulative counters are counted
+	// from. It is derived from the uptime only once per server run
+	// as samples are not taken exactly at the time the uptime
+	// (with one-second resolution) changes.
+	serverStart time.Time
+}
+
+func (c *Collector) Name() string {
+	return Name
+}
+
+func (c *Collector) Interval() time.Duration {
+	return c.conf.GetInterval(c.env)
+}
+
+func (c *Collector) Tables() []string {
+	return []string{reporting.MariaDBTSCLStatusMonitoringTable}
+}
+
+// RequiredPrivileges returns no privileges as global status
+// is available to any user
+func (c *Collector) RequiredPrivileges() []string {
+	return []string{}
+}
+
+// Collect obtains the current status and provides a record
+// with differences since the previous one. The record contains
+// the actual time elapsed since the previous sample as ticks
+// may be delayed or missed. The first call only sets the baseline
+// and provides no records.
+func (c *Collector) Collect(ctx context.Context) ([]reporting.Timescalable, error) {
+	sampleTime := c.env.Now()
+	status, err := db.GetDBStatus(ctx, c.env.DB)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Any("currStatus", status).Send()
+	restarted := c.prevStatus != nil && status.IsResetSince(c.prevStatus)
+	if restarted {
+		log.Warn().Msg("status counters decreased (server restarted?), using absolute values")
+	}
+	if c.prevStatus == nil || restarted {
+		c.serverStart = sampleTime.Add(-time.Duration(status.Uptime) * time.Second)
+	}
+	var ans []reporting.Timescalable
+	if c.prevStatus != nil {
+		ans = append(ans, &reporting.ConnectionsStatus{
+			Created:     sampleTime,
+			Instance:    c.env.InstanceName,
+			IntervalMs:  int(sampleTime.Sub(c.prevTime).Milliseconds()),
+			Status:      status.Delta(c.prevStatus),
+			Totals:      status,
+			TotalsStart: c.serverStart,
+		})
+	}
+	c.prevStatus = status
+	c.prevTime = sampleTime
+	return ans, nil
+}
+
+func newCollector(env *collector.Env, conf json.RawMessage) (collector.Collector, error) {
+	ans := &Collector{env: env}
+	if err := collector.ParseConf(conf, &ans.conf); err != nil {
+		return nil, err
+	}
+	if err := ans.conf.Validate(Name); err != nil {
+		return nil, err
+	}
+	return ans, nil
+}
+
+func init() {
+	collector.Register(Name, newCollector)
+	reporting.RegisterTableRecords(&reporting.ConnectionsStatus{})
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package globalstatus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/collector"
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/db/dbtest"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/czcorpus/mariadb-tscl/reporting/reportingtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCollector(t *testing.T, results ...*dbtest.Result) collector.Collector {
+	server, err := dbtest.NewServer()
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	server.Expect("SHOW GLOBAL STATUS", results...)
+	conn, err := db.OpenDB(server.Conf())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	coll, err := collector.New(
+		Name,
+		&collector.Env{DB: conn, InstanceName: "test", DefaultInterval: 10 * time.Second},
+		nil,
+	)
+	require.NoError(t, err)
+	return coll
+}
+
+func collectInto(t *testing.T, coll collector.Collector, writer *reportingtest.Writer) {
+	records, err := coll.Collect(context.Background())
+	require.NoError(t, err)
+	for _, rec := range records {
+		writer.Write(rec)
+	}
+}
+
+func TestCollectDeltas(t *testing.T) {
+	coll := newTestCollector(
+		t,
+		dbtest.StatusResult(map[string]any{
+			"Threads_connected": 5,
+			"Com_select":        100,
+			"Com_update":        10,
+			"Com_delete":        3,
+		}),
+		dbtest.StatusResult(map[string]any{
+			"Threads_connected": 7,
+			"Com_select":        180,
+			"Com_update":        15,
+			"Com_delete":        4,
+		}),
+	)
+	writer := reportingtest.NewWriter()
+	for _, table := range coll.Tables() {
+		writer.AddTableWriter(table)
+	}
+
+	collectInto(t, coll, writer)
+	assert.Empty(t, writer.Entries(), "the first collection only sets the baseline")
+
+	collectInto(t, coll, writer)
+	entries := writer.Entries()
+	require.Len(t, entries, 1)
+	values := entries[0].Values()
+	assert.Equal(t, reporting.MariaDBTSCLStatusMonitoringTable, entries[0].Table)
+	assert.Equal(t, "test", values["instance"])
+	assert.Equal(t, 7, values["threads_connected"])
+	assert.Equal(t, 80, values["com_select"])
+	assert.Equal(t, 5, values["com_update"])
+	assert.Equal(t, 1, values["com_delete"])
+	assert.Empty(t, writer.Dropped())
+}
+
+func TestCollectAfterServerRestart(t *testing.T) {
+	coll := newTestCollector(
+		t,
+		dbtest.StatusResult(map[string]any{"Com_select": 1000, "Bytes_sent": 50000}),
+		dbtest.StatusResult(map[string]any{"Com_select": 1200, "Bytes_sent": 60000}),
+		dbtest.StatusResult(map[string]any{"Com_select": 20, "Bytes_sent": 700}),
+		dbtest.StatusResult(map[string]any{"Com_select": 50, "Bytes_sent": 900}),
+	)
+	writer := reportingtest.NewWriter()
+	writer.AddTableWriter(reporting.MariaDBTSCLStatusMonitoringTable)
+	for i := 0; i < 4; i++ {
+		collectInto(t, coll, writer)
+	}
+	entries := writer.Entries()
+	require.Len(t, entries, 3)
+	assert.Equal(t, 200, entries[0].Values()["com_select"])
+	// counters decreased - absolute values are used
+	assert.Equal(t, 20, entries[1].Values()["com_select"])
+	assert.Equal(t, 700, entries[1].Values()["bytes_sent"])
+	assert.Equal(t, 30, entries[2].Values()["com_select"])
+	assert.Equal(t, 200, entries[2].Values()["bytes_sent"])
+}
+
+func TestCollectKeepsServerStart(t *testing.T) {
+	server, err := dbtest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+	server.Expect(
+		"SHOW GLOBAL STATUS",
+		dbtest.StatusResult(map[string]any{"Com_select": 10, "Uptime": 100}),
+		dbtest.StatusResult(map[string]any{"Com_select": 20, "Uptime": 110}),
+		dbtest.StatusResult(map[string]any{"Com_select": 30, "Uptime": 120}),
+		dbtest.StatusResult(map[string]any{"Com_select": 5, "Uptime": 3}),
+	)
+	conn, err := db.OpenDB(server.Conf())
+	require.NoError(t, err)
+	defer conn.Close()
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	// samples are taken with sub-second skews relative to the uptime
+	sampleTimes := []time.Time{
+		start.Add(100 * time.Second),
+		start.Add(110*time.Second + 400*time.Millisecond),
+		start.Add(120*time.Second + 900*time.Millisecond),
+		start.Add(200*time.Second + 300*time.Millisecond),
+	}
+	var now time.Time
+	env := &collector.Env{DB: conn, InstanceName: "test", Clock: func() time.Time { return now }}
+	coll, err := collector.New(Name, env, nil)
+	require.NoError(t, err)
+
+	var starts []time.Time
+	for _, now = range sampleTimes {
+		records, err := coll.Collect(context.Background())
+		require.NoError(t, err)
+		for _, rec := range records {
+			since, _, ok := rec.(reporting.CumulativeRecord).CumulativeValues()
+			require.True(t, ok)
+			starts = append(starts, since)
+		}
+	}
+	require.Len(t, starts, 3)
+	assert.Equal(t, start, starts[0])
+	assert.Equal(t, start, starts[1])
+	// the server has been restarted
+	assert.Equal(t, sampleTimes[3].Add(-3*time.Second), starts[2])
+}
+
+func TestCollectQueryError(t *testing.T) {
+	coll := newTestCollector(t, &dbtest.Result{Err: errors.New("access denied")})
+	records, err := coll.Collect(context.Background())
+	assert.Error(t, err)
+	assert.Empty(t, records)
+}
@@ -19,9 +19,120 @@
 
 package db
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/general"
+)
+
+const (
+	dfltMaxOpenConns    = 2
+	dfltMaxIdleConns    = 2
+	dfltConnMaxLifetime = time.Hour
+)
+
 type Conf struct {
 	Name     string `json:"name"`
 	Host     string `json:"host"`
 	User     string `json:"user"`
 	Password string `json:"password"`
+
+	// Socket is a path to the server's Unix socket. If set,
+	// the connection is made via the socket and Host is ignored.
+	Socket string `json:"socket"`
+
+	// TLS enables encrypted connection (TCP only)
+	TLS *TLSConf `json:"tls"`
+
+	// MaxOpenConns, MaxIdleConns and ConnMaxLifetime configure
+	// the connection pool. Zero values mean defaults.
+	MaxOpenConns    int              `json:"maxOpenConns"`
+	MaxIdleConns    int              `json:"maxIdleConns"`
+	ConnMaxLifetime general.Duration `json:"connMaxLifetime"`
+
+	// QueryTimeout limits duration of each collector query.
+	// By default, it is derived from the check interval.
+	QueryTimeout general.Duration `json:"queryTimeout"`
+
+	// PasswordFile is a path to a file containing the password.
+	// The file must not be readable by group or others.
+	PasswordFile string `json:"passwordFile"`
+
+	// OptionFile is a path to a MySQL option file (e.g. `~/.my.cnf`)
+	// providing credentials and connection parameters in its [client]
+	// section. Values from the file are used only for fields not
+	// set in the configuration.
+	OptionFile string `json:"optionFile"`
+}
+
+// GetQueryTimeout provides a timeout for collector queries. If not
+// configured explicitly, half of the collecting interval is used.
+func (conf *Conf) GetQueryTimeout(interval time.Duration) time.Duration {
+	if conf.QueryTimeout > 0 {
+		return conf.QueryTimeout.Duration()
+	}
+	return interval / 2
+}
+
+// Address provides a human readable address of the server
+func (conf *Conf) Address() string {
+	if conf.Socket != "" {
+		return "unix:" + conf.Socket
+	}
+	return conf.Host
+}
+
+// ResolveCredentials loads the password from the password file
+// and missing connection parameters from the option file
+// (if configured).
+func (conf *Conf) ResolveCredentials() error {
+	if conf.PasswordFile != "" {
+		if conf.Password != "" {
+			return errors.New("both db.password and db.passwordFile are set")
+		}
+		passwd, err := general.ReadSecretFile(conf.PasswordFile)
+		if err != nil {
+			return err
+		}
+		conf.Password = passwd
+	}
+	if conf.OptionFile != "" {
+		path, err := general.ExpandHomeDir(conf.OptionFile)
+		if err != nil {
+			return fmt.Errorf("failed to resolve option file path: %w", err)
+		}
+		opts, err := readOptionFile(path)
+		if err != nil {
+			return err
+		}
+		if conf.User == "" {
+			conf.User = opts["user"]
+		}
+		if conf.Password == "" {
+			conf.Password = opts["password"]
+		}
+		if conf.Name == "" {
+			conf.Name = opts["database"]
+		}
+		if conf.Socket == "" && conf.Host == "" {
+			conf.Socket = opts["socket"]
+		}
+		if conf.TLS == nil && opts["ssl-ca"] != "" {
+			conf.TLS = &TLSConf{
+				CAFile:   opts["ssl-ca"],
+				CertFile: opts["ssl-cert"],
+				KeyFile:  opts["ssl-key"],
+			}
+		}
+		if conf.Host == "" && opts["host"] != "" {
+			conf.Host = opts["host"]
+			if port := opts["port"]; port != "" && !strings.Contains(conf.Host, ":") {
+				conf.Host += ":" + port
+			}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,41 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package dbtest provides a stand-in for MariaDB with results scripted
+// by tests so collectors can be tested without a real database server.
+// It is a thin layer over the server used for replaying recorded data.
+package dbtest
+
+import "github.com/czcorpus/mariadb-tscl/snapshot/replayserver"
+
+// Server is a fake MariaDB server listening on a local TCP port
+type Server = replayserver.Server
+
+// Result is a scripted result of a query
+type Result = replayserver.Result
+
+// NewServer starts a new server on a random local port
+func NewServer() (*Server, error) {
+	return replayserver.NewServer()
+}
+
+// StatusResult creates a result of SHOW GLOBAL STATUS (or SHOW GLOBAL
+// VARIABLES) out of variable names and their values
+func StatusResult(values map[string]any) *Result {
+	return replayserver.StatusResult(values)
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2025 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// GetGlobalStatus provides all the numeric global status variables
+// of the server. Keys are lowercased variable names.
+func GetGlobalStatus(ctx context.Context, conn *sql.DB) (map[string]int64, error) {
+	rows, err := conn.QueryContext(ctx, "SHOW GLOBAL STATUS")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ans := make(map[string]int64)
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		iv, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue // non-numeric values are of no use here
+		}
+		ans[strings.ToLower(k)] = iv
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ans, nil
+}
+
+// GetGlobalVariables provides all the global server variables.
+// Keys are lowercased variable names.
+func GetGlobalVariables(ctx context.Context, conn *sql.DB) (map[string]string, error) {
+	rows, err := conn.QueryContext(ctx, "SHOW GLOBAL VARIABLES")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ans := make(map[string]string)
+	for rows.Next() {
+		var k string
+		var v sql.NullString
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		ans[strings.ToLower(k)] = v.String
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ans, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2025 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// optionFileSections are sections of a MySQL option file
+// relevant for a client connection (later ones take precedence)
+var optionFileSections = map[string]int{
+	"client":         1,
+	"client-server":  2,
+	"client-mariadb": 3,
+}
+
+// readOptionFile reads client connection options from a MySQL/MariaDB
+// option file (e.g. ~/.my.cnf). Only the [client], [client-server] and
+// [client-mariadb] sections are taken into account.
+func readOptionFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read option file: %w", err)
+	}
+	defer f.Close()
+	ans := make(map[string]string)
+	precedence := make(map[string]int)
+	var currSection int
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("invalid section header in %s on line %d", path, lineNum)
+			}
+			currSection = optionFileSections[strings.TrimSpace(line[1:len(line)-1])]
+			continue
+		}
+		if currSection == 0 || strings.HasPrefix(line, "!") { // also skips !include directives
+			continue
+		}
+		key, value, _ := strings.Cut(line, "=")
+		key = strings.ReplaceAll(strings.TrimSpace(key), "_", "-")
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if precedence[key] <= currSection {
+			ans[key] = value
+			precedence[key] = currSection
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read option file: %w", err)
+	}
+	return ans, nil
+}
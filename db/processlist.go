@@ -0,0 +1,75 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2025 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Process represents a single entry of the server's processlist
+type Process struct {
+	ID      int64  `json:"id"`
+	User    string `json:"user"`
+	Host    string `json:"host"`
+	DB      string `json:"db"`
+	Command string `json:"command"`
+	Time    int    `json:"time"`
+	State   string `json:"state"`
+	Info    string `json:"info"`
+}
+
+// GetLongestProcesses provides up to `limit` longest running
+// non-idle processes (excluding the current connection).
+// To see processes of other users, the PROCESS privilege
+// is required.
+func GetLongestProcesses(ctx context.Context, conn *sql.DB, limit int) ([]Process, error) {
+	rows, err := conn.QueryContext(
+		ctx,
+		"SELECT ID, USER, HOST, DB, COMMAND, TIME, STATE, INFO "+
+			"FROM information_schema.PROCESSLIST "+
+			"WHERE COMMAND <> 'Sleep' AND ID <> CONNECTION_ID() "+
+			"ORDER BY TIME DESC LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ans := make([]Process, 0, limit)
+	for rows.Next() {
+		var proc Process
+		var procDB, state, info sql.NullString
+		if err := rows.Scan(
+			&proc.ID, &proc.User, &proc.Host, &procDB, &proc.Command,
+			&proc.Time, &state, &info,
+		); err != nil {
+			return nil, err
+		}
+		proc.DB = procDB.String
+		proc.State = state.String
+		proc.Info = info.String
+		ans = append(ans, proc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ans, nil
+}
@@ -20,7 +20,10 @@
 package db
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"time"
@@ -47,17 +50,76 @@ type Status struct {
 	HandlerReadRndNext           int `json:"handlerReadRndNext"`
 	BytesSent                    int `json:"bytesSent"`
 	BytesReceived                int `json:"bytesReceived"`
+
+	// Uptime is the number of seconds since the server start.
+	// It is not reported, it only serves to detect restarts
+	// and the start of the cumulative counters.
+	Uptime int `json:"-"`
+}
+
+// IsResetSince tests whether any of the cumulative counters
+// (or the uptime) decreased since the prev status which means
+// the server has been restarted (or the counters have been flushed).
+func (s *Status) IsResetSince(prev *Status) bool {
+	d := s.diff(prev)
+	return s.Uptime < prev.Uptime || d.AbortedConnects < 0 || d.ComSelect < 0 || d.ComInsert < 0 ||
+		d.ComUpdate < 0 || d.ComDelete < 0 || d.SlowQueries < 0 ||
+		d.InnodbBufferPoolReads < 0 || d.InnodbBufferPoolReadRequests < 0 ||
+		d.InnodbRowLockTime < 0 || d.HandlerReadFirst < 0 || d.HandlerReadKey < 0 ||
+		d.HandlerReadNext < 0 || d.HandlerReadRnd < 0 || d.HandlerReadRndNext < 0 ||
+		d.BytesSent < 0 || d.BytesReceived < 0
+}
+
+// Delta calculates a status between the prev status and this one.
+// Cumulative counters are converted into differences, gauges
+// (connected threads, max. used connections, uptime) are kept
+// as they are.
+// In case the counters have been reset since the prev status
+// (see IsResetSince), the current values are returned as they
+// represent the difference since the reset.
+func (s *Status) Delta(prev *Status) Status {
+	if s.IsResetSince(prev) {
+		return *s
+	}
+	return s.diff(prev)
+}
+
+func (s *Status) diff(prev *Status) Status {
+	return Status{
+		ThreadsConnected:             s.ThreadsConnected,
+		MaxUsedConnections:           s.MaxUsedConnections,
+		AbortedConnects:              s.AbortedConnects - prev.AbortedConnects,
+		ComSelect:                    s.ComSelect - prev.ComSelect,
+		ComInsert:                    s.ComInsert - prev.ComInsert,
+		ComUpdate:                    s.ComUpdate - prev.ComUpdate,
+		ComDelete:                    s.ComDelete - prev.ComDelete,
+		SlowQueries:                  s.SlowQueries - prev.SlowQueries,
+		InnodbBufferPoolReads:        s.InnodbBufferPoolReads - prev.InnodbBufferPoolReads,
+		InnodbBufferPoolReadRequests: s.InnodbBufferPoolReadRequests - prev.InnodbBufferPoolReadRequests,
+		InnodbRowLockTime:            s.InnodbRowLockTime - prev.InnodbRowLockTime,
+		HandlerReadFirst:             s.HandlerReadFirst - prev.HandlerReadFirst,
+		HandlerReadKey:               s.HandlerReadKey - prev.HandlerReadKey,
+		HandlerReadNext:              s.HandlerReadNext - prev.HandlerReadNext,
+		HandlerReadRnd:               s.HandlerReadRnd - prev.HandlerReadRnd,
+		HandlerReadRndNext:           s.HandlerReadRndNext - prev.HandlerReadRndNext,
+		BytesSent:                    s.BytesSent - prev.BytesSent,
+		BytesReceived:                s.BytesReceived - prev.BytesReceived,
+		Uptime:                       s.Uptime,
+	}
 }
 
 func (conf *Conf) Validate(context string) error {
-	if conf.Name == "" && conf.Host == "" && conf.User == "" && conf.Password == "" {
+	if conf.Name == "" && conf.Host == "" && conf.Socket == "" && conf.User == "" && conf.Password == "" {
 		return errors.New("database not configured")
 
 	} else if conf.Name == "" {
 		return fmt.Errorf("%s.name is missing/empty", context)
 
-	} else if conf.Host == "" {
-		return fmt.Errorf("%s.host is missing/empty", context)
+	} else if conf.Host == "" && conf.Socket == "" {
+		return fmt.Errorf("%s.host or %s.socket must be set", context, context)
+
+	} else if conf.Socket != "" && conf.TLS != nil {
+		return fmt.Errorf("%s.tls cannot be used along with %s.socket", context, context)
 
 	} else if conf.User == "" {
 		return fmt.Errorf("%s.user is missing/empty", context)
@@ -65,56 +127,98 @@ func (conf *Conf) Validate(context string) error {
 	} else if conf.Password == "" {
 		return fmt.Errorf("%s.password is missing/empty", context)
 	}
+	if conf.MaxOpenConns < 0 || conf.MaxIdleConns < 0 {
+		return fmt.Errorf("%s.maxOpenConns and %s.maxIdleConns must not be negative", context, context)
+	}
+	if conf.ConnMaxLifetime < 0 || conf.QueryTimeout < 0 {
+		return fmt.Errorf("%s.connMaxLifetime and %s.queryTimeout must not be negative", context, context)
+	}
+	if conf.TLS != nil {
+		return conf.TLS.Validate(context + ".tls")
+	}
 	return nil
 }
 
-func OpenDB(conf *Conf) (*sql.DB, error) {
+// NewConnector creates a connector to the database described
+// by the configuration
+func NewConnector(conf *Conf) (driver.Connector, error) {
 	mconf := mysql.NewConfig()
-	mconf.Net = "tcp"
-	mconf.Addr = conf.Host
+	if conf.Socket != "" {
+		mconf.Net = "unix"
+		mconf.Addr = conf.Socket
+
+	} else {
+		mconf.Net = "tcp"
+		mconf.Addr = conf.Host
+	}
+	if conf.TLS != nil {
+		tlsName, err := registerTLSConfig(conf.TLS)
+		if err != nil {
+			return nil, err
+		}
+		mconf.TLSConfig = tlsName
+	}
 	mconf.User = conf.User
 	mconf.Passwd = conf.Password
 	mconf.DBName = conf.Name
 	mconf.ParseTime = true
 	mconf.Loc = time.Local
 	mconf.Params = map[string]string{"autocommit": "false"}
-	db, err := sql.Open("mysql", mconf.FormatDSN())
+	return mysql.NewConnector(mconf)
+}
+
+// SetupPool applies configured connection pool settings
+func SetupPool(db *sql.DB, conf *Conf) {
+	db.SetMaxOpenConns(cmp.Or(conf.MaxOpenConns, dfltMaxOpenConns))
+	db.SetMaxIdleConns(cmp.Or(conf.MaxIdleConns, dfltMaxIdleConns))
+	db.SetConnMaxLifetime(cmp.Or(conf.ConnMaxLifetime.Duration(), dfltConnMaxLifetime))
+}
+
+func OpenDB(conf *Conf) (*sql.DB, error) {
+	connector, err := NewConnector(conf)
 	if err != nil {
 		return nil, err
 	}
+	db := sql.OpenDB(connector)
+	SetupPool(db, conf)
 	return db, nil
 }
 
-func GetDBStatus(conn *sql.DB) (*Status, error) {
+func GetDBStatus(ctx context.Context, conn *sql.DB) (*Status, error) {
 	var s Status
-	rows, err := conn.Query(
-		"SHOW GLOBAL STATUS WHERE Variable_name IN (" +
-			"'Threads_connected', " +
-			"'Max_used_connections', " +
-			"'Aborted_connects', " + // cummulative
-			"'Com_select', " + // cummulative
-			"'Com_insert', " + // cummulative
-			"'Com_update', " + // cummulative
-			"'Com_delete', " + // cummulative
-			"'Slow_queries', " + // cummulative
-			"'Innodb_buffer_pool_reads', " + // cummulative
-			"'Innodb_buffer_pool_read_requests', " + // cummulative
-			"'Innodb_row_lock_time', " + // cummulative
-			"'Handler_read_first', " + // cummulative
-			"'Handler_read_key', " + // cummulative
-			"'Handler_read_next', " + // cummulative
-			"'Handler_read_rnd', " + // cummulative
-			"'Handler_read_rnd_next', " + // cummulative,
-			"'Bytes_sent', " + // cummulative
-			"'Bytes_received' " + // cummulative
+	rows, err := conn.QueryContext(
+		ctx,
+		"SHOW GLOBAL STATUS WHERE Variable_name IN ("+
+			"'Threads_connected', "+
+			"'Max_used_connections', "+
+			"'Aborted_connects', "+ // cummulative
+			"'Com_select', "+ // cummulative
+			"'Com_insert', "+ // cummulative
+			"'Com_update', "+ // cummulative
+			"'Com_delete', "+ // cummulative
+			"'Slow_queries', "+ // cummulative
+			"'Innodb_buffer_pool_reads', "+ // cummulative
+			"'Innodb_buffer_pool_read_requests', "+ // cummulative
+			"'Innodb_row_lock_time', "+ // cummulative
+			"'Handler_read_first', "+ // cummulative
+			"'Handler_read_key', "+ // cummulative
+			"'Handler_read_next', "+ // cummulative
+			"'Handler_read_rnd', "+ // cummulative
+			"'Handler_read_rnd_next', "+ // cummulative,
+			"'Bytes_sent', "+ // cummulative
+			"'Bytes_received', "+ // cummulative
+			"'Uptime' "+
 			")")
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	for rows.Next() {
 		var k string
 		var v int
-		rows.Scan(&k, &v)
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
 		switch k {
 		case "Threads_connected":
 			s.ThreadsConnected = v
@@ -152,7 +256,12 @@ func GetDBStatus(conn *sql.DB) (*Status, error) {
 			s.BytesSent = v
 		case "Bytes_received":
 			s.BytesReceived = v
+		case "Uptime":
+			s.Uptime = v
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return &s, nil
 }
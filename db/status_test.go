@@ -0,0 +1,65 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeltaCounters(t *testing.T) {
+	prev := Status{ComSelect: 100, ComDelete: 7, BytesSent: 1000, SlowQueries: 1}
+	curr := Status{ComSelect: 150, ComDelete: 9, BytesSent: 1600, SlowQueries: 1}
+	delta := curr.Delta(&prev)
+	assert.Equal(t, 50, delta.ComSelect)
+	assert.Equal(t, 2, delta.ComDelete)
+	assert.Equal(t, 600, delta.BytesSent)
+	assert.Equal(t, 0, delta.SlowQueries)
+}
+
+func TestDeltaKeepsGauges(t *testing.T) {
+	prev := Status{ThreadsConnected: 10, MaxUsedConnections: 20}
+	curr := Status{ThreadsConnected: 4, MaxUsedConnections: 20}
+	delta := curr.Delta(&prev)
+	assert.Equal(t, 4, delta.ThreadsConnected)
+	assert.Equal(t, 20, delta.MaxUsedConnections)
+	assert.False(t, curr.IsResetSince(&prev))
+}
+
+func TestDeltaAfterReset(t *testing.T) {
+	prev := Status{ComSelect: 1000, ComInsert: 500, ThreadsConnected: 8}
+	curr := Status{ComSelect: 30, ComInsert: 600, ThreadsConnected: 2}
+	assert.True(t, curr.IsResetSince(&prev))
+	assert.Equal(t, curr, curr.Delta(&prev))
+}
+
+func TestIsResetSinceAnyCounter(t *testing.T) {
+	prev := Status{BytesReceived: 10}
+	curr := Status{BytesReceived: 9}
+	assert.True(t, curr.IsResetSince(&prev))
+}
+
+func TestIsResetSinceUptime(t *testing.T) {
+	prev := Status{ComSelect: 10, Uptime: 3600}
+	curr := Status{ComSelect: 20, Uptime: 5}
+	assert.True(t, curr.IsResetSince(&prev))
+	curr.Uptime = 3610
+	assert.False(t, curr.IsResetSince(&prev))
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2025 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	TLSVerifyFull = "full"
+	TLSVerifyCA   = "ca"
+	TLSVerifySkip = "skip"
+)
+
+// tlsConfigCounter makes names of registered TLS configurations
+// unique (a configuration may be registered repeatedly, e.g. after
+// a configuration reload)
+var tlsConfigCounter atomic.Int64
+
+// TLSConf configures an encrypted connection to the database
+type TLSConf struct {
+
+	// CAFile is a path to a PEM file with CA certificate(s) used to verify
+	// the server's certificate. If empty, system roots are used.
+	CAFile string `json:"caFile"`
+
+	// CertFile and KeyFile specify a client certificate
+	// for authentication (optional)
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+
+	// ServerName overrides the host name used to verify
+	// the server's certificate
+	ServerName string `json:"serverName"`
+
+	// VerifyMode is one of "full" (default; verify both certificate chain
+	// and host name), "ca" (verify only certificate chain) and "skip"
+	// (no verification at all; not recommended)
+	VerifyMode string `json:"verifyMode"`
+}
+
+func (conf *TLSConf) Validate(context string) error {
+	switch conf.VerifyMode {
+	case "", TLSVerifyFull, TLSVerifyCA, TLSVerifySkip:
+	default:
+		return fmt.Errorf(
+			"%s.verifyMode must be one of %s, %s, %s", context, TLSVerifyFull, TLSVerifyCA, TLSVerifySkip)
+	}
+	if (conf.CertFile == "") != (conf.KeyFile == "") {
+		return fmt.Errorf("%s.certFile and %s.keyFile must be set together", context, context)
+	}
+	return nil
+}
+
+// verifyChain verifies server certificates against roots
+// without checking the host name
+func verifyChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("server provided no certificate")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("failed to parse server certificate: %w", err)
+			}
+			certs[i] = cert
+		}
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(opts)
+		return err
+	}
+}
+
+// NewTLSConfig creates a crypto/tls configuration out of TLSConf
+func (conf *TLSConf) NewTLSConfig() (*tls.Config, error) {
+	ans := &tls.Config{
+		ServerName: conf.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if conf.CAFile != "" {
+		pem, err := os.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		ans.RootCAs = x509.NewCertPool()
+		if !ans.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificates found in %s", conf.CAFile)
+		}
+	}
+	if conf.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		ans.Certificates = []tls.Certificate{cert}
+	}
+	switch conf.VerifyMode {
+	case TLSVerifyCA:
+		ans.InsecureSkipVerify = true
+		ans.VerifyPeerCertificate = verifyChain(ans.RootCAs)
+	case TLSVerifySkip:
+		ans.InsecureSkipVerify = true
+	}
+	return ans, nil
+}
+
+// registerTLSConfig registers the TLS configuration with
+// the MySQL driver and returns its name to be used in DSN.
+func registerTLSConfig(conf *TLSConf) (string, error) {
+	tlsConf, err := conf.NewTLSConfig()
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("mariadb-tscl-%d", tlsConfigCounter.Add(1))
+	if err := mysql.RegisterTLSConfig(name, tlsConf); err != nil {
+		return "", fmt.Errorf("failed to register TLS config: %w", err)
+	}
+	return name, nil
+}
@@ -21,7 +21,6 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"flag"
 	"fmt"
 	"os"
@@ -31,13 +30,15 @@ import (
 	"time"
 
 	"github.com/czcorpus/cnc-gokit/logging"
-	"github.com/czcorpus/hltscl"
+	"github.com/czcorpus/mariadb-tscl/advisor"
 	"github.com/czcorpus/mariadb-tscl/cnf"
-	"github.com/czcorpus/mariadb-tscl/db"
 	"github.com/czcorpus/mariadb-tscl/general"
-	"github.com/czcorpus/mariadb-tscl/reporting"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/czcorpus/mariadb-tscl/monitor"
+	"github.com/czcorpus/mariadb-tscl/snapshot"
 	"github.com/rs/zerolog/log"
+
+	// built-in collectors
+	_ "github.com/czcorpus/mariadb-tscl/collector/globalstatus"
 )
 
 var (
@@ -46,6 +47,14 @@ var (
 	gitCommit string
 )
 
+func loadConfig(path string) *cnf.Conf {
+	conf, err := cnf.LoadConfig(path)
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+	return conf
+}
+
 func main() {
 	version := general.VersionInfo{
 		Version:   version,
@@ -54,8 +63,19 @@ func main() {
 	}
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "MariaDB-TSCL\n\nUsage:\n\t%s [options] start [config.json]\n\t%s [options] version\n",
-			filepath.Base(os.Args[0]), filepath.Base(os.Args[0]))
+		fmt.Fprintf(
+			os.Stderr,
+			"MariaDB-TSCL\n\nUsage:\n"+
+				"\t%[1]s [options] start [config.json]\n"+
+				"\t%[1]s [options] check [config.json]\n"+
+				"\t%[1]s [options] once [config.json] [--interval 5s] [--format table|json|influx]\n"+
+				"\t%[1]s [options] top [config.json...] [--interval 2s] [--processes 10] [--history 1h]\n"+
+				"\t%[1]s [options] advise [config.json] [--days N] [--format text|json]\n"+
+				"\t%[1]s [options] record [config.json] --output snapshots.jsonl.gz\n"+
+				"\t%[1]s [options] replay [config.json] --input snapshots.jsonl.gz [--speed 60]\n"+
+				"\t%[1]s [options] version\n",
+			filepath.Base(os.Args[0]),
+		)
 		flag.PrintDefaults()
 	}
 	flag.Parse()
@@ -64,86 +84,177 @@ func main() {
 		fmt.Printf("mariadb-tscl %s\nbuild date: %s\nlast commit: %s\n", version.Version, version.BuildDate, version.GitCommit)
 		return
 
+	} else if action == "check" {
+		if !printCheckReport(os.Stdout, runChecks(context.Background(), flag.Arg(1))) {
+			os.Exit(1)
+		}
+		return
+
+	} else if action == "once" {
+		onceFlags := flag.NewFlagSet("once", flag.ExitOnError)
+		interval := onceFlags.Duration("interval", 5*time.Second, "time between the two status samples")
+		format := onceFlags.String("format", onceFormatTable, "output format (table, json, influx)")
+		args, err := parseSubcommandArgs(onceFlags, flag.Args()[1:])
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		if len(args) == 0 {
+			args = append(args, "")
+		}
+		conf := loadConfig(args[0])
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if err := runOnce(ctx, conf, *interval, *format, os.Stdout); err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		return
+
+	} else if action == "top" {
+		topFlags := flag.NewFlagSet("top", flag.ExitOnError)
+		interval := topFlags.Duration("interval", 2*time.Second, "refresh interval")
+		numProcesses := topFlags.Int("processes", 10, "number of the longest running processes to show")
+		historyPeriod := topFlags.Duration("history", time.Hour, "period of history (stored by the SQLite sink) to summarize (0 = disabled)")
+		args, err := parseSubcommandArgs(topFlags, flag.Args()[1:])
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		if len(args) == 0 {
+			args = append(args, "")
+		}
+		confs := make([]*cnf.Conf, len(args))
+		for i, path := range args {
+			confs[i] = loadConfig(path)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if err := runTop(ctx, confs, *interval, *numProcesses, *historyPeriod, os.Stdout); err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		return
+
+	} else if action == "advise" {
+		adviseFlags := flag.NewFlagSet("advise", flag.ExitOnError)
+		days := adviseFlags.Int("days", 0, "base the assessment on the last N days of history (reporting database or SQLite sink)")
+		format := adviseFlags.String("format", "text", "output format (text, json)")
+		args, err := parseSubcommandArgs(adviseFlags, flag.Args()[1:])
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		if len(args) == 0 {
+			args = append(args, "")
+		}
+		conf := loadConfig(args[0])
+		input, err := loadAdvisorInput(context.Background(), conf, *days)
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		if err := printAdvice(os.Stdout, input, advisor.Evaluate(input), *format); err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		return
+
+	} else if action == "replay" {
+		replayFlags := flag.NewFlagSet("replay", flag.ExitOnError)
+		input := replayFlags.String("input", "", "a file with recorded snapshots")
+		speed := replayFlags.Float64("speed", 60, "replay speed-up factor (0 = as fast as possible)")
+		args, err := parseSubcommandArgs(replayFlags, flag.Args()[1:])
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		if len(args) == 0 {
+			args = append(args, "")
+		}
+		conf := loadConfig(args[0])
+		logging.SetupLogging(conf.Logging)
+		snapshots, err := snapshot.ReadFile(*input)
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		stats, err := monitor.Replay(ctx, conf, snapshots, *speed)
+		if err != nil {
+			log.Fatal().Err(err).Any("stats", stats).Msg("replay failed")
+		}
+		log.Info().Any("stats", stats).Msg("replay finished")
+		return
+
+	} else if action == "record" {
+		recordFlags := flag.NewFlagSet("record", flag.ExitOnError)
+		output := recordFlags.String("output", "", "a file to write recorded snapshots to")
+		args, err := parseSubcommandArgs(recordFlags, flag.Args()[1:])
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		if *output == "" {
+			log.Fatal().Msg("missing --output")
+		}
+		if len(args) == 0 {
+			args = append(args, "")
+		}
+		conf := loadConfig(args[0])
+		logging.SetupLogging(conf.Logging)
+		writer, err := snapshot.Create(*output)
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		log.Info().Str("output", *output).Msg("Starting MariaDB-TSCL in the record mode")
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		service, err := monitor.NewRecordingService(ctx, conf, snapshot.NewRecorder(writer))
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		runService(ctx, service, args[0])
+		if err := writer.Close(); err != nil {
+			log.Error().Err(err).Send()
+		}
+		log.Info().Int("snapshots", writer.Written()).Msg("recording finished")
+		return
+
 	} else if action != "start" {
 		log.Fatal().Msgf("Unknown action %s", action)
 	}
-	conf := cnf.LoadConfig(flag.Arg(1))
+	confPath := flag.Arg(1)
+	conf := loadConfig(confPath)
 	logging.SetupLogging(conf.Logging)
 	log.Info().Msg("Starting MariaDB-TSCL")
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	mariadb, err := db.OpenDB(conf.DB)
+	service, err := monitor.NewService(ctx, conf)
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
+	runService(ctx, service, confPath)
+}
 
-	var tDBWriter reporting.ReportingWriter
-	var pg *pgxpool.Pool
-	if conf.Reporting != nil {
-		pg, err = hltscl.CreatePool(conf.Reporting.DB)
-		if err != nil {
-			log.Fatal().Err(err).Send()
-		}
-		tDBWriter = reporting.NewReportingWriter(pg, conf.GetLocation(), ctx)
-	} else {
-		tDBWriter = &reporting.NullWriter{}
-	}
-	tDBWriter.AddTableWriter(reporting.MariaDBTSCLStatusMonitoringTable)
-	tDBWriter.LogErrors()
+// runService runs the service until the context is cancelled.
+// The configuration is reloaded on SIGHUP.
+func runService(ctx context.Context, service *monitor.Service, confPath string) {
+	service.Start()
 
-	ticker := time.NewTicker(conf.CheckInterval * time.Second)
-	go func(ctx context.Context, mariadb *sql.DB, tDBWriter reporting.ReportingWriter) {
-		prevStatus, err := db.GetDBStatus(mariadb)
-		if err != nil {
-			log.Error().Err(err).Msg("failed to obtain initial db status")
-		}
-		log.Debug().Any("prevStatus", prevStatus).Send()
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
 
-		for range ticker.C {
-			status, err := db.GetDBStatus(mariadb)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Stopping...")
+			service.Stop()
+			return
+		case <-reload:
+			log.Info().Msg("SIGHUP received, reloading configuration")
+			newConf, err := cnf.LoadConfig(confPath)
 			if err != nil {
-				log.Error().Err(err).Msg("failed to obtain db status")
-
-			} else {
-				log.Debug().Any("currStatus", status).Send()
-				tDBWriter.Write(&reporting.ConnectionsStatus{
-					Created:  time.Now(),
-					Instance: conf.InstanceName,
-					Status: db.Status{
-						ThreadsConnected:             status.ThreadsConnected,
-						MaxUsedConnections:           status.MaxUsedConnections,
-						AbortedConnects:              status.AbortedConnects - prevStatus.AbortedConnects,
-						ComSelect:                    status.ComSelect - prevStatus.ComSelect,
-						ComInsert:                    status.ComInsert - prevStatus.ComInsert,
-						ComUpdate:                    status.ComUpdate - prevStatus.ComUpdate,
-						ComDelete:                    status.ComDelete - prevStatus.ComDelete,
-						SlowQueries:                  status.SlowQueries - prevStatus.SlowQueries,
-						InnodbBufferPoolReads:        status.InnodbBufferPoolReads - prevStatus.InnodbBufferPoolReads,
-						InnodbBufferPoolReadRequests: status.InnodbBufferPoolReadRequests - prevStatus.InnodbBufferPoolReadRequests,
-						InnodbRowLockTime:            status.InnodbRowLockTime - prevStatus.InnodbRowLockTime,
-						HandlerReadFirst:             status.HandlerReadFirst - prevStatus.HandlerReadFirst,
-						HandlerReadKey:               status.HandlerReadKey - prevStatus.HandlerReadKey,
-						HandlerReadNext:              status.HandlerReadNext - prevStatus.HandlerReadNext,
-						HandlerReadRnd:               status.HandlerReadRnd - prevStatus.HandlerReadRnd,
-						HandlerReadRndNext:           status.HandlerReadRndNext - prevStatus.HandlerReadRndNext,
-						BytesSent:                    status.BytesSent - prevStatus.BytesSent,
-						BytesReceived:                status.BytesReceived - prevStatus.BytesReceived,
-					},
-				})
-				prevStatus = status
+				log.Error().Err(err).Msg("failed to reload configuration, keeping the current one")
+				continue
+			}
+			if err := service.Reload(newConf); err != nil {
+				log.Error().Err(err).Msg("failed to apply configuration, keeping the current one")
 			}
 		}
-	}(ctx, mariadb, tDBWriter)
-
-	<-ctx.Done()
-	log.Info().Msg("Stopping...")
-	if pg != nil {
-		pg.Close()
-	}
-	err = mariadb.Close()
-	if err != nil {
-		log.Error().Err(err).Send()
 	}
 }
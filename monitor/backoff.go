@@ -0,0 +1,55 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// backoff calculates delays between retries of a failing operation.
+// The delay grows exponentially from min to max and it is randomized
+// (jitter) between a half and the full value so multiple instances
+// do not retry in sync.
+type backoff struct {
+	min      time.Duration
+	max      time.Duration
+	failures int
+}
+
+// next registers a failure and provides a delay
+// before the next attempt
+func (b *backoff) next() time.Duration {
+	delay := b.min
+	for i := 0; i < b.failures && delay < b.max; i++ {
+		delay *= 2
+	}
+	delay = min(delay, b.max)
+	b.failures++
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// reset should be called after a successful attempt
+func (b *backoff) reset() {
+	b.failures = 0
+}
+
+func (b *backoff) isFailing() bool {
+	return b.failures > 0
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/cnf"
+	"github.com/czcorpus/mariadb-tscl/collector"
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/czcorpus/mariadb-tscl/snapshot"
+	"github.com/czcorpus/mariadb-tscl/snapshot/replayserver"
+	"github.com/rs/zerolog/log"
+)
+
+// ReplayStats summarizes a finished replay
+type ReplayStats struct {
+	Collections int `json:"collections"`
+	Records     int `json:"records"`
+	Errors      int `json:"errors"`
+	Skipped     int `json:"skipped"`
+}
+
+// Replay runs recorded query results through the configured collectors
+// and writes produced records to the configured reporting sink. Gaps
+// between recorded collector runs are shortened `speed` times (with
+// speed <= 0, there are no delays at all). Collectors see the recorded
+// time as the current time so records keep their original timestamps.
+func Replay(
+	ctx context.Context,
+	conf *cnf.Conf,
+	snapshots []snapshot.Snapshot,
+	speed float64,
+) (ReplayStats, error) {
+	var stats ReplayStats
+	snapshots = slices.Clone(snapshots)
+	slices.SortStableFunc(snapshots, func(a, b snapshot.Snapshot) int {
+		return a.Time.Compare(b.Time)
+	})
+	server, err := replayserver.NewServerFromSnapshots(snapshots)
+	if err != nil {
+		return stats, err
+	}
+	defer server.Close()
+	mariadb, err := db.OpenDB(server.Conf())
+	if err != nil {
+		return stats, err
+	}
+	defer mariadb.Close()
+
+	var currTime time.Time
+	env := &collector.Env{
+		DB:              mariadb,
+		InstanceName:    conf.InstanceName,
+		DefaultInterval: conf.CheckInterval.Duration(),
+		Clock:           func() time.Time { return currTime },
+	}
+	collectors, err := createCollectors(env, conf, nil)
+	if err != nil {
+		return stats, err
+	}
+	sink, err := openReportingSink(conf, collectorTables(collectors, conf))
+	if err != nil {
+		return stats, err
+	}
+	defer sink.close(conf.ShutdownTimeout.Duration())
+
+	runs := make([]collector.Collection, 0, len(snapshots))
+	seenRuns := make(map[collector.Collection]bool)
+	for _, snap := range snapshots {
+		run := collector.Collection{Collector: snap.Collector, Time: snap.Time}
+		if !seenRuns[run] {
+			runs = append(runs, run)
+			seenRuns[run] = true
+		}
+	}
+	unknown := make(map[string]bool)
+	for _, run := range runs {
+		idx := slices.IndexFunc(collectors, func(sc *scheduledCollector) bool {
+			return sc.Name() == run.Collector
+		})
+		if idx < 0 {
+			if !unknown[run.Collector] {
+				log.Warn().Str("collector", run.Collector).Msg("recorded collector not configured, skipping")
+				unknown[run.Collector] = true
+			}
+			stats.Skipped++
+			continue
+		}
+		if speed > 0 && !currTime.IsZero() {
+			select {
+			case <-ctx.Done():
+				return stats, ctx.Err()
+			case <-time.After(time.Duration(float64(run.Time.Sub(currTime)) / speed)):
+			}
+		}
+		currTime = run.Time
+		records, err := collectors[idx].Collect(ctx)
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+		stats.Collections++
+		if err != nil {
+			stats.Errors++
+			log.Warn().
+				Err(err).
+				Str("collector", run.Collector).
+				Time("time", run.Time).
+				Msg("replayed collection failed")
+			continue
+		}
+		reporting.WriteAll(sink.writer, records)
+		stats.Records += len(records)
+	}
+	return stats, nil
+}
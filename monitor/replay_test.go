@@ -0,0 +1,112 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/cnf"
+	"github.com/czcorpus/mariadb-tscl/collector"
+	"github.com/czcorpus/mariadb-tscl/collector/globalstatus"
+	"github.com/czcorpus/mariadb-tscl/db/dbtest"
+	"github.com/czcorpus/mariadb-tscl/general"
+	"github.com/czcorpus/mariadb-tscl/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	server, err := dbtest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+	server.Expect(
+		"SHOW GLOBAL STATUS",
+		dbtest.StatusResult(map[string]any{"Com_select": 10}),
+		dbtest.StatusResult(map[string]any{"Com_select": 25}),
+		&dbtest.Result{Err: assert.AnError},
+		dbtest.StatusResult(map[string]any{"Com_select": 40}),
+	)
+
+	path := filepath.Join(t.TempDir(), "snapshots.jsonl.gz")
+	writer, err := snapshot.Create(path)
+	require.NoError(t, err)
+	mariadb, err := snapshot.NewRecorder(writer).OpenDB(server.Conf())
+	require.NoError(t, err)
+	defer mariadb.Close()
+	env := &collector.Env{DB: mariadb, InstanceName: "test", DefaultInterval: 10 * time.Second}
+	coll, err := collector.New(globalstatus.Name, env, nil)
+	require.NoError(t, err)
+
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		ctx := collector.WithCollection(
+			context.Background(),
+			collector.Collection{Collector: globalstatus.Name, Time: start.Add(time.Duration(i) * 10 * time.Second)},
+		)
+		coll.Collect(ctx)
+	}
+	// queries without collection context are not recorded
+	coll.Collect(context.Background())
+	require.NoError(t, writer.Close())
+
+	snapshots, err := snapshot.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 4)
+	assert.Equal(t, globalstatus.Name, snapshots[0].Collector)
+	assert.Equal(t, start, snapshots[0].Time.UTC())
+	assert.NotEmpty(t, snapshots[2].Error)
+
+	conf := &cnf.Conf{
+		InstanceName:    "test",
+		CheckInterval:   general.Duration(10 * time.Second),
+		ShutdownTimeout: general.Duration(time.Second),
+		Collectors:      map[string]json.RawMessage{globalstatus.Name: nil},
+	}
+	stats, err := Replay(context.Background(), conf, snapshots, 0)
+	require.NoError(t, err)
+	assert.Equal(t, ReplayStats{Collections: 4, Records: 2, Errors: 1}, stats)
+}
+
+func TestRecordQueryWithArgsFails(t *testing.T) {
+	server, err := dbtest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+	server.Expect("SELECT", &dbtest.Result{Columns: []string{"ID"}, Rows: [][]any{{1}}})
+
+	writer, err := snapshot.Create(filepath.Join(t.TempDir(), "snapshots.jsonl.gz"))
+	require.NoError(t, err)
+	defer writer.Close()
+	mariadb, err := snapshot.NewRecorder(writer).OpenDB(server.Conf())
+	require.NoError(t, err)
+	defer mariadb.Close()
+
+	ctx := collector.WithCollection(
+		context.Background(),
+		collector.Collection{Collector: "test", Time: time.Now()},
+	)
+	_, err = mariadb.QueryContext(ctx, "SELECT ID FROM t WHERE ID = ?", 1)
+	assert.ErrorContains(t, err, "cannot be recorded")
+	_, err = mariadb.PrepareContext(ctx, "SELECT ID FROM t WHERE ID = ?")
+	assert.ErrorContains(t, err, "cannot be recorded")
+	assert.Equal(t, 0, writer.Written())
+}
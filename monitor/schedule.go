@@ -0,0 +1,75 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/collector"
+)
+
+// schedule calculates times of collector ticks. With wall-clock
+// alignment, ticks are placed on multiples of the interval (e.g.
+// at :00, :10, :20 for a 10s interval) so records of different
+// instances line up.
+type schedule struct {
+	interval time.Duration
+
+	// offset is a random start jitter. It only delays the first
+	// collection, aligned ticks are not shifted by it.
+	offset time.Duration
+
+	// phase is a reference time of the ticks
+	phase time.Time
+}
+
+// first provides the first regular tick after `now`
+func (sch *schedule) first(now time.Time) time.Time {
+	return sch.after(sch.phase, now)
+}
+
+// after provides the first tick following `prev` which is
+// strictly after `now`. Ticks missed between the two are skipped.
+func (sch *schedule) after(prev, now time.Time) time.Time {
+	if now.Before(prev) {
+		return prev
+	}
+	return prev.Add((now.Sub(prev)/sch.interval + 1) * sch.interval)
+}
+
+// missed provides number of ticks skipped between
+// the `prev` tick and the `next` one
+func (sch *schedule) missed(prev, next time.Time) int {
+	return max(int(next.Sub(prev)/sch.interval)-1, 0)
+}
+
+func newSchedule(interval time.Duration, conf collector.ScheduleConf, now time.Time) *schedule {
+	ans := &schedule{interval: interval}
+	if conf.StartJitter > 0 {
+		ans.offset = rand.N(conf.StartJitter.Duration())
+	}
+	if conf.TickAlignment == collector.TickAlignmentWallClock {
+		ans.phase = now.Truncate(interval)
+
+	} else {
+		ans.phase = now.Add(ans.offset)
+	}
+	return ans
+}
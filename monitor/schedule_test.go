@@ -0,0 +1,51 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/collector"
+	"github.com/czcorpus/mariadb-tscl/general"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlignedScheduleIgnoresJitter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 3, 0, time.UTC)
+	conf := collector.ScheduleConf{
+		TickAlignment: collector.TickAlignmentWallClock,
+		StartJitter:   general.Duration(5 * time.Second),
+	}
+	for i := 0; i < 20; i++ {
+		sch := newSchedule(10*time.Second, conf, now)
+		assert.Less(t, sch.offset, 5*time.Second)
+		assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 10, 0, time.UTC), sch.first(now))
+		assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 20, 0, time.UTC), sch.first(now.Add(8*time.Second)))
+	}
+}
+
+func TestScheduleSkipsMissedTicks(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	sch := newSchedule(10*time.Second, collector.ScheduleConf{TickAlignment: collector.TickAlignmentWallClock}, now)
+	tick := sch.first(now)
+	next := sch.after(tick, tick.Add(25*time.Second))
+	assert.Equal(t, tick.Add(30*time.Second), next)
+	assert.Equal(t, 2, sch.missed(tick, next))
+}
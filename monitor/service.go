@@ -0,0 +1,625 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/czcorpus/cnc-gokit/logging"
+	"github.com/czcorpus/mariadb-tscl/cnf"
+	"github.com/czcorpus/mariadb-tscl/collector"
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/czcorpus/mariadb-tscl/reporting/filesink"
+	"github.com/czcorpus/mariadb-tscl/reporting/graphite"
+	"github.com/czcorpus/mariadb-tscl/reporting/influx"
+	"github.com/czcorpus/mariadb-tscl/reporting/otlp"
+	"github.com/czcorpus/mariadb-tscl/reporting/sqlite"
+	"github.com/czcorpus/mariadb-tscl/reporting/textfile"
+	"github.com/czcorpus/mariadb-tscl/selfmon"
+	"github.com/czcorpus/mariadb-tscl/snapshot"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// maxRetryDelay is the maximum delay between attempts
+// to reach an unavailable database
+const maxRetryDelay = 5 * time.Minute
+
+// reportingSink is a reporting writer along with resources
+// it depends on
+type reportingSink struct {
+	writer reporting.ReportingWriter
+	pg     *pgxpool.Pool
+	cancel context.CancelFunc
+	tables []string
+}
+
+// close writes pending records (waiting at most `timeout`)
+// and releases all the resources
+func (sink *reportingSink) close(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := sink.writer.Close(ctx); err != nil {
+		log.Error().Err(err).Msg("failed to write pending reporting records")
+	}
+	sink.cancel()
+	if sink.pg != nil {
+		sink.pg.Close()
+	}
+}
+
+// openReportingSink creates a reporting writer based on the configuration
+// with table writers for all the provided tables. Records are written
+// to all the configured outputs (or to the log if there is none). The sink
+// has its own lifecycle independent of the service context so pending
+// records can be written even after the service is cancelled.
+func openReportingSink(conf *cnf.Conf, tables []string) (*reportingSink, error) {
+	sinkCtx, cancel := context.WithCancel(context.Background())
+	ans := &reportingSink{cancel: cancel, tables: tables}
+	writers := make([]reporting.ReportingWriter, 0, 2)
+	closeWriters := func() {
+		for _, w := range writers {
+			w.Close(sinkCtx)
+		}
+		if ans.pg != nil {
+			ans.pg.Close()
+		}
+		cancel()
+	}
+	if conf.Reporting != nil {
+		pg, err := reporting.CreatePool(sinkCtx, conf.Reporting)
+		if err != nil {
+			closeWriters()
+			return nil, fmt.Errorf("failed to create reporting pool: %w", err)
+		}
+		ans.pg = pg
+		writers = append(writers, reporting.NewReportingWriter(
+			pg, conf.GetLocation(), conf.Reporting.Queue, conf.Reporting.Layout, sinkCtx))
+	}
+	if conf.Sinks.Influx != nil {
+		w, err := influx.NewWriter(conf.Sinks.Influx)
+		if err != nil {
+			closeWriters()
+			return nil, err
+		}
+		writers = append(writers, w)
+	}
+	if conf.Sinks.File != nil {
+		w, err := filesink.NewWriter(conf.Sinks.File)
+		if err != nil {
+			closeWriters()
+			return nil, err
+		}
+		writers = append(writers, w)
+	}
+	if conf.Sinks.Textfile != nil {
+		writers = append(writers, textfile.NewWriter(conf.Sinks.Textfile))
+	}
+	if conf.Sinks.Graphite != nil {
+		w, err := graphite.NewWriter(conf.Sinks.Graphite)
+		if err != nil {
+			closeWriters()
+			return nil, err
+		}
+		writers = append(writers, w)
+	}
+	if conf.Sinks.OTLP != nil {
+		writers = append(writers, otlp.NewWriter(conf.Sinks.OTLP))
+	}
+	if conf.Sinks.SQLite != nil {
+		w, err := sqlite.NewWriter(conf.Sinks.SQLite)
+		if err != nil {
+			closeWriters()
+			return nil, err
+		}
+		writers = append(writers, w)
+	}
+	if len(writers) == 0 {
+		writers = append(writers, &reporting.NullWriter{})
+	}
+	ans.writer = reporting.NewLabelingWriter(reporting.NewMultiWriter(writers...), conf.Labels)
+	for _, table := range tables {
+		ans.writer.AddTableWriter(table)
+	}
+	ans.writer.LogErrors()
+	return ans, nil
+}
+
+// loop is a background goroutine run by the service
+type loop struct {
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// startLoop runs fn in its own goroutine until the context
+// is cancelled or until the loop is stopped
+func startLoop(ctx context.Context, fn func(ctx context.Context)) *loop {
+	ctx, cancel := context.WithCancel(ctx)
+	ans := &loop{stop: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(ans.done)
+		fn(ctx)
+	}()
+	return ans
+}
+
+// stopLoops stops all the provided loops (nil values are ignored).
+// The returned channel is closed once all of them are finished.
+func stopLoops(loops ...*loop) <-chan struct{} {
+	for _, l := range loops {
+		if l != nil {
+			l.stop()
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		for _, l := range loops {
+			if l != nil {
+				<-l.done
+			}
+		}
+		close(done)
+	}()
+	return done
+}
+
+// scheduledCollector is a collector along with its scheduling state
+type scheduledCollector struct {
+	collector.Collector
+	conf json.RawMessage
+
+	// started is set once the collector is scheduled for the first
+	// time; restarted collectors do not collect immediately
+	started bool
+
+	// loop is the running collector loop (nil if not running)
+	loop *loop
+}
+
+// createCollectors instantiates all the collectors enabled in the
+// configuration. Collectors from `reusable` with unchanged configuration
+// are kept (along with their state, e.g. baselines of counters).
+func createCollectors(
+	env *collector.Env,
+	conf *cnf.Conf,
+	reusable []*scheduledCollector,
+) ([]*scheduledCollector, error) {
+	ans := make([]*scheduledCollector, 0, len(conf.Collectors))
+	for _, name := range slices.Sorted(maps.Keys(conf.Collectors)) {
+		collConf := conf.Collectors[name]
+		idx := slices.IndexFunc(reusable, func(sc *scheduledCollector) bool {
+			return sc.Name() == name && bytes.Equal(sc.conf, collConf)
+		})
+		if idx >= 0 {
+			ans = append(ans, reusable[idx])
+			continue
+		}
+		coll, err := collector.New(name, env, collConf)
+		if err != nil {
+			return nil, err
+		}
+		ans = append(ans, &scheduledCollector{Collector: coll, conf: collConf})
+	}
+	return ans, nil
+}
+
+// collectorTables provides sorted names of all the reporting
+// tables required by collectors and self-monitoring
+func collectorTables(collectors []*scheduledCollector, conf *cnf.Conf) []string {
+	ans := make([]string, 0, len(collectors)+1)
+	for _, coll := range collectors {
+		ans = append(ans, coll.Tables()...)
+	}
+	if conf.SelfMonitoring != nil {
+		ans = append(ans, reporting.MariaDBTSCLSelfTable)
+	}
+	slices.Sort(ans)
+	return slices.Compact(ans)
+}
+
+// Service periodically runs all the enabled collectors and writes
+// obtained records to the reporting database. The service can be
+// reconfigured while running (see Reload).
+type Service struct {
+	ctx        context.Context
+	conf       *cnf.Conf
+	env        *collector.Env
+	collectors []*scheduledCollector
+
+	// sink can be replaced while collectors are running; writers
+	// hold sinkMu for reading so no record is written to a sink
+	// which is being closed
+	sink   *reportingSink
+	sinkMu sync.RWMutex
+
+	selfMonitor *selfmon.Monitor
+	selfLoop    *loop
+
+	// openDB opens connections to the monitored database
+	openDB func(conf *db.Conf) (*sql.DB, error)
+}
+
+// write passes the records to the current reporting sink
+func (s *Service) write(records []reporting.Timescalable) {
+	s.sinkMu.RLock()
+	defer s.sinkMu.RUnlock()
+	reporting.WriteAll(s.sink.writer, records)
+}
+
+// replaceSink makes the service write to a new sink and
+// closes the previous one
+func (s *Service) replaceSink(sink *reportingSink) {
+	s.sinkMu.Lock()
+	prev := s.sink
+	s.sink = sink
+	s.selfMonitor.ResetQueueBaseline()
+	s.sinkMu.Unlock()
+	prev.close(s.conf.ShutdownTimeout.Duration())
+}
+
+// runCollector runs the collector's loop until the context is cancelled.
+// Ticks follow the configured schedule. If a tick is missed (e.g. because
+// of a slow query), it is not caught up but reported as skipped. In case
+// the database is unreachable, attempts are spaced using an exponential
+// backoff. The function is expected to run in its own goroutine.
+func (s *Service) runCollector(ctx context.Context, sc *scheduledCollector, conf *cnf.Conf) {
+	interval := sc.Interval()
+	queryTimeout := conf.DB.GetQueryTimeout(interval)
+	retry := backoff{min: interval, max: max(interval, maxRetryDelay)}
+	stats := s.selfMonitor.Collector(sc.Name())
+	sch := newSchedule(interval, conf.Schedule, time.Now())
+	var nextAttempt time.Time
+
+	attempt := func() {
+		now := time.Now()
+		if now.Before(nextAttempt) {
+			stats.AddSkippedTicks(1)
+			return
+		}
+		qctx, cancel := context.WithTimeout(
+			collector.WithCollection(ctx, collector.Collection{Collector: sc.Name(), Time: now}),
+			queryTimeout,
+		)
+		records, err := sc.Collect(qctx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		stats.ObserveCollection(time.Since(now), err)
+		if err != nil {
+			delay := retry.next()
+			nextAttempt = now.Add(delay)
+			if retry.failures == 1 {
+				log.Error().
+					Err(err).
+					Str("collector", sc.Name()).
+					Dur("retryIn", delay).
+					Msg("failed to collect data")
+
+			} else {
+				log.Warn().
+					Err(err).
+					Str("collector", sc.Name()).
+					Int("failedAttempts", retry.failures).
+					Dur("retryIn", delay).
+					Msg("data still unavailable")
+			}
+			return
+		}
+		s.write(records)
+		if retry.isFailing() {
+			log.Info().
+				Str("collector", sc.Name()).
+				Int("failedAttempts", retry.failures).
+				Msg("data available again")
+			retry.reset()
+			nextAttempt = time.Time{}
+		}
+	}
+
+	wait := func(until time.Time) bool {
+		timer := time.NewTimer(time.Until(until))
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		}
+	}
+
+	if !sc.started {
+		// the first collection (usually setting a baseline)
+		// is not aligned, only the start jitter applies
+		if !wait(time.Now().Add(sch.offset)) {
+			return
+		}
+		sc.started = true
+		attempt()
+	}
+	tick := sch.first(time.Now())
+	for {
+		if !wait(tick) {
+			return
+		}
+		attempt()
+		next := sch.after(tick, time.Now())
+		if missed := sch.missed(tick, next); missed > 0 {
+			stats.AddSkippedTicks(missed)
+			log.Warn().
+				Str("collector", sc.Name()).
+				Int("missedTicks", missed).
+				Msg("collector ticks missed")
+		}
+		tick = next
+	}
+}
+
+// reportSelf periodically writes self-monitoring records
+// until the context is cancelled
+func (s *Service) reportSelf(ctx context.Context, conf *cnf.Conf) {
+	ticker := time.NewTicker(conf.SelfMonitoring.Interval.Duration())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sinkMu.RLock()
+			reporting.WriteAll(s.sink.writer, s.selfMonitor.Report(conf.InstanceName, s.sink.writer.QueueStats()))
+			s.sinkMu.RUnlock()
+		}
+	}
+}
+
+func (s *Service) startCollector(sc *scheduledCollector) {
+	conf := s.conf
+	sc.loop = startLoop(s.ctx, func(ctx context.Context) {
+		s.runCollector(ctx, sc, conf)
+	})
+}
+
+func (s *Service) startSelfReporting() {
+	if s.conf.SelfMonitoring == nil {
+		s.selfLoop = nil
+		return
+	}
+	conf := s.conf
+	s.selfLoop = startLoop(s.ctx, func(ctx context.Context) {
+		s.reportSelf(ctx, conf)
+	})
+}
+
+// stopCollecting stops all the collectors and self-monitoring.
+// The returned channel is closed once all of them are finished.
+func (s *Service) stopCollecting() <-chan struct{} {
+	loops := make([]*loop, 0, len(s.collectors)+1)
+	for _, sc := range s.collectors {
+		loops = append(loops, sc.loop)
+	}
+	return stopLoops(append(loops, s.selfLoop)...)
+}
+
+// Reload applies a new configuration. Only the parts affected
+// by the changes are reinitialized - e.g. the connection to the
+// monitored database is reopened only if its configuration
+// changed and collectors (including their baselines) are kept
+// unless their configuration or the monitored server itself
+// changed. In case the new configuration cannot be applied,
+// the service keeps running with the old one.
+func (s *Service) Reload(newConf *cnf.Conf) error {
+	dbChanged := !reflect.DeepEqual(s.conf.DB, newConf.DB)
+	targetChanged := s.conf.DB.Address() != newConf.DB.Address() ||
+		s.conf.DB.Name != newConf.DB.Name
+
+	var newMariadb *sql.DB
+	if dbChanged {
+		var err error
+		newMariadb, err = s.openDB(newConf.DB)
+		if err != nil {
+			return fmt.Errorf("failed to open database: %w", err)
+		}
+		if err := newMariadb.PingContext(s.ctx); err != nil {
+			newMariadb.Close()
+			return fmt.Errorf("failed to connect to database: %w", err)
+		}
+	}
+	closeNewMariadb := func() {
+		if newMariadb != nil {
+			newMariadb.Close()
+		}
+	}
+
+	reusable := s.collectors
+	if targetChanged {
+		reusable = nil
+	}
+	newCollectors, err := createCollectors(s.env, newConf, reusable)
+	if err != nil {
+		closeNewMariadb()
+		return err
+	}
+	collectorsChanged := len(newCollectors) != len(s.collectors)
+	for i := 0; !collectorsChanged && i < len(newCollectors); i++ {
+		collectorsChanged = newCollectors[i] != s.collectors[i]
+	}
+
+	newTables := collectorTables(newCollectors, newConf)
+	sinkChanged := !reflect.DeepEqual(s.conf.Reporting, newConf.Reporting) ||
+		!reflect.DeepEqual(s.conf.Sinks, newConf.Sinks) ||
+		s.conf.TimeZone != newConf.TimeZone ||
+		!maps.Equal(s.conf.Labels, newConf.Labels) ||
+		!slices.Equal(s.sink.tables, newTables)
+	var newSink *reportingSink
+	if sinkChanged {
+		newSink, err = openReportingSink(newConf, newTables)
+		if err != nil {
+			closeNewMariadb()
+			return err
+		}
+	}
+
+	if !reflect.DeepEqual(s.conf.Logging, newConf.Logging) {
+		logging.SetupLogging(newConf.Logging)
+	}
+	// changes of the environment shared by all the collectors
+	// require all of them to be restarted
+	envChanged := dbChanged ||
+		s.conf.Schedule != newConf.Schedule ||
+		s.conf.CheckInterval != newConf.CheckInterval ||
+		s.conf.InstanceName != newConf.InstanceName
+	selfChanged := !reflect.DeepEqual(s.conf.SelfMonitoring, newConf.SelfMonitoring) ||
+		s.conf.InstanceName != newConf.InstanceName
+	if !envChanged && !sinkChanged && !collectorsChanged && !selfChanged {
+		s.conf = newConf
+		log.Info().Msg("configuration reloaded, no changes affecting collecting")
+		return nil
+	}
+
+	stopped := make([]*loop, 0, len(s.collectors))
+	for _, sc := range s.collectors {
+		if envChanged || !slices.Contains(newCollectors, sc) {
+			stopped = append(stopped, sc.loop)
+			sc.loop = nil
+		}
+	}
+	<-stopLoops(stopped...)
+	if newMariadb != nil {
+		if targetChanged {
+			log.Info().Msg("monitored database changed, collectors reset")
+		}
+		if err := s.env.DB.Close(); err != nil {
+			log.Error().Err(err).Msg("failed to close previous database connection")
+		}
+		s.env.DB = newMariadb
+	}
+	if envChanged {
+		s.env.InstanceName = newConf.InstanceName
+		s.env.DefaultInterval = newConf.CheckInterval.Duration()
+	}
+	if newSink != nil {
+		s.replaceSink(newSink)
+	}
+	if selfChanged {
+		<-stopLoops(s.selfLoop)
+	}
+	s.collectors = newCollectors
+	s.conf = newConf
+	var started int
+	for _, sc := range s.collectors {
+		if sc.loop == nil {
+			s.startCollector(sc)
+			started++
+		}
+	}
+	if selfChanged {
+		s.startSelfReporting()
+	}
+	log.Info().
+		Bool("dbReopened", dbChanged).
+		Bool("reportingReopened", sinkChanged).
+		Int("collectorsStopped", len(stopped)).
+		Int("collectorsStarted", started).
+		Msg("configuration reloaded")
+	return nil
+}
+
+// Start starts collecting in the background
+func (s *Service) Start() {
+	for _, sc := range s.collectors {
+		s.startCollector(sc)
+	}
+	s.startSelfReporting()
+}
+
+// Stop stops collecting (waiting for unfinished collecting
+// rounds), writes pending records and closes all the connections.
+func (s *Service) Stop() {
+	stopped := s.stopCollecting()
+	select {
+	case <-stopped:
+	case <-time.After(s.conf.ShutdownTimeout.Duration()):
+		// collecting may be blocked by a full reporting queue (the "block"
+		// overflow policy); closing the sink releases it and makes the
+		// writer drop all the records written afterwards
+		log.Warn().Msg("collecting did not finish in time, closing reporting first")
+	}
+	s.sink.close(s.conf.ShutdownTimeout.Duration())
+	<-stopped
+	if err := s.env.DB.Close(); err != nil {
+		log.Error().Err(err).Send()
+	}
+}
+
+// NewService creates a new service with opened connections
+// to all the configured databases and instantiated collectors.
+func NewService(ctx context.Context, conf *cnf.Conf) (*Service, error) {
+	return newService(ctx, conf, db.OpenDB)
+}
+
+// NewRecordingService creates a service which, in addition to the
+// normal operation, records results of all the collector queries
+func NewRecordingService(ctx context.Context, conf *cnf.Conf, recorder *snapshot.Recorder) (*Service, error) {
+	return newService(ctx, conf, recorder.OpenDB)
+}
+
+func newService(
+	ctx context.Context,
+	conf *cnf.Conf,
+	openDB func(conf *db.Conf) (*sql.DB, error),
+) (*Service, error) {
+	mariadb, err := openDB(conf.DB)
+	if err != nil {
+		return nil, err
+	}
+	env := &collector.Env{
+		DB:              mariadb,
+		InstanceName:    conf.InstanceName,
+		DefaultInterval: conf.CheckInterval.Duration(),
+	}
+	collectors, err := createCollectors(env, conf, nil)
+	if err != nil {
+		mariadb.Close()
+		return nil, err
+	}
+	sink, err := openReportingSink(conf, collectorTables(collectors, conf))
+	if err != nil {
+		mariadb.Close()
+		return nil, err
+	}
+	return &Service{
+		ctx:         ctx,
+		conf:        conf,
+		env:         env,
+		collectors:  collectors,
+		sink:        sink,
+		selfMonitor: selfmon.NewMonitor(),
+		openDB:      openDB,
+	}, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/cnf"
+	"github.com/czcorpus/mariadb-tscl/collector"
+	"github.com/czcorpus/mariadb-tscl/db/dbtest"
+	"github.com/czcorpus/mariadb-tscl/general"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type idleCollector struct {
+	name string
+}
+
+func (c *idleCollector) Name() string                 { return c.name }
+func (c *idleCollector) Interval() time.Duration      { return time.Hour }
+func (c *idleCollector) Tables() []string             { return nil }
+func (c *idleCollector) RequiredPrivileges() []string { return nil }
+
+func (c *idleCollector) Collect(ctx context.Context) ([]reporting.Timescalable, error) {
+	return nil, nil
+}
+
+func init() {
+	for _, name := range []string{"idle-a", "idle-b"} {
+		collector.Register(name, func(env *collector.Env, conf json.RawMessage) (collector.Collector, error) {
+			return &idleCollector{name: name}, nil
+		})
+	}
+}
+
+func TestReloadRestartsOnlyAffectedCollectors(t *testing.T) {
+	server, err := dbtest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+	conf := &cnf.Conf{
+		DB:              server.Conf(),
+		InstanceName:    "test",
+		CheckInterval:   general.Duration(time.Hour),
+		ShutdownTimeout: general.Duration(time.Second),
+		Collectors: map[string]json.RawMessage{
+			"idle-a": json.RawMessage(`{"v":1}`),
+			"idle-b": json.RawMessage(`{"v":1}`),
+		},
+	}
+	service, err := NewService(context.Background(), conf)
+	require.NoError(t, err)
+	service.Start()
+	defer service.Stop()
+	collA, collB := service.collectors[0], service.collectors[1]
+	loopA, sink := collA.loop, service.sink
+
+	// a change of a single collector
+	newConf := *conf
+	newConf.Collectors = maps.Clone(conf.Collectors)
+	newConf.Collectors["idle-b"] = json.RawMessage(`{"v":2}`)
+	require.NoError(t, service.Reload(&newConf))
+	require.Len(t, service.collectors, 2)
+	assert.Same(t, collA, service.collectors[0])
+	assert.Same(t, loopA, collA.loop)
+	assert.NotSame(t, collB, service.collectors[1])
+	assert.NotNil(t, service.collectors[1].loop)
+	assert.Nil(t, collB.loop)
+	assert.Same(t, sink, service.sink)
+
+	// a change of the sink only
+	collB = service.collectors[1]
+	loopB := collB.loop
+	sinkConf := newConf
+	sinkConf.TimeZone = "UTC"
+	require.NoError(t, service.Reload(&sinkConf))
+	assert.Same(t, loopA, collA.loop)
+	assert.Same(t, loopB, collB.loop)
+	assert.NotSame(t, sink, service.sink)
+	select {
+	case <-loopA.done:
+		assert.Fail(t, "collector stopped by a sink change")
+	default:
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//
+//	              Faculty of Arts, Charles University
+//	 This file is part of MARIADB-TSCL.
+//
+//	MARIADB-TSCL is free software: you can redistribute it and/or modify
+//	it under the terms of the GNU General Public License as published by
+//	the Free Software Foundation, either version 3 of the License, or
+//	(at your option) any later version.
+//
+//	MARIADB-TSCL is distributed in the hope that it will be useful,
+//	but WITHOUT ANY WARRANTY; without even the implied warranty of
+//	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//	GNU General Public License for more details.
+//
+//	You should have received a copy of the GNU General Public License
+//	along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/cnf"
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+)
+
+const (
+	onceFormatTable  = "table"
+	onceFormatJSON   = "json"
+	onceFormatInflux = "influx"
+)
+
+// parseSubcommandArgs parses flags of a subcommand while allowing
+// them to be mixed with positional arguments (e.g. `once conf.json --interval 5s`).
+// Positional arguments are returned.
+func parseSubcommandArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0, len(args))
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	return positional, nil
+}
+
+// runOnce takes two status samples `interval` apart and writes
+// the resulting record (the same one the daemon would report)
+// to `w` in a specified format.
+func runOnce(
+	ctx context.Context,
+	conf *cnf.Conf,
+	interval time.Duration,
+	format string,
+	w io.Writer,
+) error {
+	if format != onceFormatTable && format != onceFormatJSON && format != onceFormatInflux {
+		return fmt.Errorf("unknown output format %s", format)
+	}
+	mariadb, err := db.OpenDB(conf.DB)
+	if err != nil {
+		return err
+	}
+	defer mariadb.Close()
+	prevTime := time.Now()
+	prevStatus, err := db.GetDBStatus(ctx, mariadb)
+	if err != nil {
+		return fmt.Errorf("failed to obtain initial db status: %w", err)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(interval):
+	}
+	sampleTime := time.Now()
+	status, err := db.GetDBStatus(ctx, mariadb)
+	if err != nil {
+		return fmt.Errorf("failed to obtain db status: %w", err)
+	}
+	record := &reporting.ConnectionsStatus{
+		Created:    sampleTime,
+		Instance:   conf.InstanceName,
+		IntervalMs: int(sampleTime.Sub(prevTime).Milliseconds()),
+		Status:     status.Delta(prevStatus),
+	}
+	return writeRecord(w, record, sampleTime.Sub(prevTime), format)
+}
+
+// writeRecord writes the record along with the time actually
+// elapsed between the samples (which may exceed the requested
+// interval, e.g. because of slow queries)
+func writeRecord(w io.Writer, record *reporting.ConnectionsStatus, elapsed time.Duration, format string) error {
+	switch format {
+	case onceFormatTable:
+		tags, fields := record.ToInfluxDB()
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "time\t%s\n", record.GetTime().Format(time.RFC3339))
+		fmt.Fprintf(tw, "interval\t%s\n", elapsed.Round(time.Millisecond))
+		for _, k := range slices.Sorted(maps.Keys(tags)) {
+			fmt.Fprintf(tw, "%s\t%s\n", k, tags[k])
+		}
+		for _, k := range slices.Sorted(maps.Keys(fields)) {
+			fmt.Fprintf(tw, "%s\t%v\n", k, fields[k])
+		}
+		return tw.Flush()
+	case onceFormatJSON:
+		data, err := record.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case onceFormatInflux:
+		tags, fields := record.ToInfluxDB()
+		_, err := fmt.Fprintln(
+			w, reporting.FormatLineProtocol(record.GetTableName(), record.GetTime(), tags, fields))
+		return err
+	default:
+		return fmt.Errorf("unknown output format %s", format)
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package reporting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// PermanentError marks errors which cannot be fixed
+// by repeating the request (e.g. malformed data)
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// BatchQueueConf configures a BatchQueue
+type BatchQueueConf[T any] struct {
+
+	// Name identifies the queue in logs and in QueueStats
+	Name string
+
+	// BatchSize is the maximum number of items sent at once
+	// (zero means no limit). Reaching it triggers a flush.
+	BatchSize int
+
+	// BufferSize is the maximum number of items kept in the queue.
+	// The oldest items are dropped when it is exceeded.
+	BufferSize int
+
+	FlushInterval time.Duration
+
+	// MaxRetries is the number of times a batch failed because
+	// of a transient error is sent again (with the retry delay
+	// doubled each time) before it is returned to the queue
+	MaxRetries int
+	RetryDelay time.Duration
+
+	// NextBatch optionally provides the number of leading pending
+	// items to be sent at once (e.g. to fit a size limit).
+	// By default, it is given by BatchSize.
+	NextBatch func(pending []T) int
+}
+
+// BatchQueue keeps items of an output sending them in batches
+// (in the configured interval and whenever a batch is full).
+// Batches which fail because of a transient error are retried
+// and in case the output stays unavailable, items are kept
+// (up to the buffer size) for the next flush. Batches failed
+// because of a PermanentError are counted as write errors.
+type BatchQueue[T any] struct {
+	conf BatchQueueConf[T]
+	send func(ctx context.Context, batch []T) error
+
+	mu          sync.Mutex
+	pending     []T
+	inFlight    int
+	dropped     int64
+	writeErrors int64
+	closed      bool
+
+	flushCh chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+
+	// sendCtx is cancelled only if pending items cannot
+	// be sent during Close
+	sendCtx    context.Context
+	cancelSend context.CancelFunc
+}
+
+// Push adds items to the queue. Items pushed to a closed
+// queue are dropped.
+func (q *BatchQueue[T]) Push(items ...T) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		log.Warn().Str("queue", q.conf.Name).Int("items", len(items)).Msg("write to a closed writer, items dropped")
+		return
+	}
+	q.pending = append(q.pending, items...)
+	q.trimPending()
+	if q.conf.BatchSize > 0 && len(q.pending) >= q.conf.BatchSize {
+		q.requestFlush()
+	}
+}
+
+// trimPending drops the oldest items exceeding the buffer size.
+// The caller must hold the lock.
+func (q *BatchQueue[T]) trimPending() {
+	if over := len(q.pending) + q.inFlight - q.conf.BufferSize; over > 0 {
+		over = min(over, len(q.pending))
+		q.pending = q.pending[over:]
+		q.dropped += int64(over)
+		log.Warn().Str("queue", q.conf.Name).Int("dropped", over).Msg("buffer full, dropping the oldest items")
+	}
+}
+
+func (q *BatchQueue[T]) requestFlush() {
+	select {
+	case q.flushCh <- struct{}{}:
+	default:
+	}
+}
+
+// takeBatch moves a batch of pending items to the in-flight state
+func (q *BatchQueue[T]) takeBatch() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.pending)
+	if q.conf.NextBatch != nil && n > 0 {
+		n = min(max(q.conf.NextBatch(q.pending), 1), n)
+
+	} else if q.conf.BatchSize > 0 {
+		n = min(n, q.conf.BatchSize)
+	}
+	batch := q.pending[:n:n]
+	q.pending = q.pending[n:]
+	q.inFlight = n
+	return batch
+}
+
+// finishBatch settles the in-flight batch. Items of batches which
+// could not be sent because of transient errors are returned
+// to the front of the pending items.
+func (q *BatchQueue[T]) finishBatch(batch []T, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.inFlight = 0
+	var perr *PermanentError
+	if err == nil {
+		return
+
+	} else if errors.As(err, &perr) {
+		q.writeErrors += int64(len(batch))
+		return
+	}
+	q.pending = append(batch, q.pending...)
+	q.trimPending()
+}
+
+// sendBatch sends a batch, retrying in case of transient errors
+func (q *BatchQueue[T]) sendBatch(batch []T) error {
+	delay := q.conf.RetryDelay
+	var err error
+	for attempt := 0; attempt <= q.conf.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-q.sendCtx.Done():
+				return err
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+		err = q.send(q.sendCtx, batch)
+		var perr *PermanentError
+		if err == nil || errors.As(err, &perr) {
+			return err
+		}
+		log.Warn().Err(err).Str("queue", q.conf.Name).Int("attempt", attempt+1).Msg("failed to send batch")
+	}
+	return err
+}
+
+// sendPending sends all the pending items. It stops on the first
+// batch which cannot be sent because of a transient error.
+func (q *BatchQueue[T]) sendPending() {
+	for {
+		batch := q.takeBatch()
+		if len(batch) == 0 {
+			return
+		}
+		err := q.sendBatch(batch)
+		q.finishBatch(batch, err)
+		if err != nil {
+			log.Error().Err(err).Str("queue", q.conf.Name).Int("items", len(batch)).Msg("failed to send batch")
+			var perr *PermanentError
+			if !errors.As(err, &perr) {
+				return
+			}
+		}
+	}
+}
+
+func (q *BatchQueue[T]) run() {
+	defer close(q.done)
+	ticker := time.NewTicker(q.conf.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stop:
+			q.sendPending()
+			return
+		case <-ticker.C:
+			q.sendPending()
+		case <-q.flushCh:
+			q.sendPending()
+		}
+	}
+}
+
+// Len provides the number of items waiting to be sent
+// (including the batch being sent)
+func (q *BatchQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending) + q.inFlight
+}
+
+// Stats provides state of the queue
+func (q *BatchQueue[T]) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return QueueStats{
+		Table:       q.conf.Name,
+		Depth:       len(q.pending) + q.inFlight,
+		Capacity:    q.conf.BufferSize,
+		Dropped:     q.dropped,
+		WriteErrors: q.writeErrors,
+	}
+}
+
+// Flush requests sending of all the pending items and waits until
+// they are sent or until the context is done. Items which cannot
+// be sent (even after retries) stay pending until the next flush.
+func (q *BatchQueue[T]) Flush(ctx context.Context) error {
+	q.requestFlush()
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+	for q.Len() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to flush %d pending items of %s: %w", q.Len(), q.conf.Name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Close stops accepting new items and sends the pending ones.
+// If the context is done before that, unfinished sends are cancelled
+// and an error is returned.
+func (q *BatchQueue[T]) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.mu.Unlock()
+	close(q.stop)
+	defer q.cancelSend()
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+		q.cancelSend()
+		<-q.done
+		return fmt.Errorf("failed to send %d pending items of %s: %w", q.Len(), q.conf.Name, ctx.Err())
+	}
+	if n := q.Len(); n > 0 {
+		return fmt.Errorf("failed to send %d pending items of %s", n, q.conf.Name)
+	}
+	return nil
+}
+
+// NewBatchQueue creates a queue sending items using the provided
+// function and starts its background loop. The function is called
+// from a single goroutine; it should return a PermanentError for
+// batches which would fail again.
+func NewBatchQueue[T any](conf BatchQueueConf[T], send func(ctx context.Context, batch []T) error) *BatchQueue[T] {
+	sendCtx, cancelSend := context.WithCancel(context.Background())
+	ans := &BatchQueue[T]{
+		conf:       conf,
+		send:       send,
+		flushCh:    make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		sendCtx:    sendCtx,
+		cancelSend: cancelSend,
+	}
+	go ans.run()
+	return ans
+}
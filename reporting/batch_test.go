@@ -0,0 +1,61 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package reporting
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchQueueErrors(t *testing.T) {
+	var sent [][]int
+	var failing error
+	conf := BatchQueueConf[int]{Name: "test", BatchSize: 2, BufferSize: 10, FlushInterval: time.Hour}
+	queue := NewBatchQueue(conf, func(ctx context.Context, batch []int) error {
+		if failing != nil {
+			return failing
+		}
+		sent = append(sent, batch)
+		return nil
+	})
+
+	// a transient error keeps the items
+	failing = errors.New("unavailable")
+	queue.Push(1, 2, 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.Error(t, queue.Flush(ctx))
+	assert.Equal(t, 3, queue.Stats().Depth)
+
+	// a permanent error drops the batch
+	failing = &PermanentError{Err: errors.New("malformed")}
+	require.NoError(t, queue.Flush(context.Background()))
+	assert.Equal(t, int64(3), queue.Stats().WriteErrors)
+
+	failing = nil
+	queue.Push(4, 5, 6)
+	require.NoError(t, queue.Close(context.Background()))
+	assert.Equal(t, [][]int{{4, 5}, {6}}, sent)
+	assert.Zero(t, queue.Stats().Dropped)
+}
@@ -0,0 +1,156 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// tableRecords contains (empty) instances of all the record types
+// written to respective tables
+var tableRecords = map[string][]Timescalable{
+	MariaDBTSCLSelfTable: {&CollectorSelfStatus{}, &ProcessSelfStatus{}},
+}
+
+// RegisterTableRecords registers record types so the structure of
+// their tables can be checked (see ExpectedColumns). The function
+// is expected to be called from init functions of packages
+// providing records.
+func RegisterTableRecords(records ...Timescalable) {
+	for _, rec := range records {
+		tableRecords[rec.GetTableName()] = append(tableRecords[rec.GetTableName()], rec)
+	}
+}
+
+// TableRecords provides (empty) instances of the record
+// types registered for the table
+func TableRecords(tableName string) []Timescalable {
+	return slices.Clone(tableRecords[tableName])
+}
+
+// ExpectedColumns provides names of all the columns filled in
+// by the record types registered for the table
+func ExpectedColumns(tableName string) []string {
+	ans := make([]string, 0, 20)
+	for _, rec := range tableRecords[tableName] {
+		for _, col := range TableColumns(rec) {
+			if !slices.Contains(ans, col) {
+				ans = append(ans, col)
+			}
+		}
+	}
+	return ans
+}
+
+// TableColumns provides names of all the columns the writer
+// fills in when storing the item (including the time column).
+func TableColumns(item Timescalable) []string {
+	tags, fields := item.ToInfluxDB()
+	ans := make([]string, 0, len(tags)+len(fields)+1)
+	ans = append(ans, TimeColumnName)
+	ans = append(ans, slices.Sorted(maps.Keys(tags))...)
+	return append(ans, slices.Sorted(maps.Keys(fields))...)
+}
+
+// CheckTable tests whether the table exists, whether it is
+// a TimescaleDB hypertable and whether it contains all the
+// required columns.
+func CheckTable(ctx context.Context, conn *pgxpool.Pool, tableName string, columns []string) error {
+	var numHypertables int
+	err := conn.QueryRow(
+		ctx,
+		"SELECT COUNT(*) FROM timescaledb_information.hypertables "+
+			"WHERE hypertable_schema = current_schema() AND hypertable_name = $1",
+		tableName,
+	).Scan(&numHypertables)
+	if err != nil {
+		return fmt.Errorf("failed to look up hypertable %s: %w", tableName, err)
+	}
+	if numHypertables == 0 {
+		return fmt.Errorf("table %s does not exist or is not a hypertable", tableName)
+	}
+	return checkColumns(ctx, conn, tableName, columns)
+}
+
+// checkColumns tests whether the table exists
+// and whether it contains all the required columns
+func checkColumns(ctx context.Context, conn *pgxpool.Pool, tableName string, columns []string) error {
+	rows, err := conn.Query(
+		ctx,
+		"SELECT column_name FROM information_schema.columns "+
+			"WHERE table_schema = current_schema() AND table_name = $1",
+		tableName,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to read columns of %s: %w", tableName, err)
+	}
+	defer rows.Close()
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return fmt.Errorf("failed to read columns of %s: %w", tableName, err)
+		}
+		existing[col] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read columns of %s: %w", tableName, err)
+	}
+	if len(existing) == 0 {
+		return fmt.Errorf("table %s does not exist", tableName)
+	}
+	missing := make([]string, 0, len(columns))
+	for _, col := range columns {
+		if !existing[col] {
+			missing = append(missing, col)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("table %s is missing columns: %s", tableName, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// CheckURLReachable tests whether a TCP connection to the host
+// of the URL can be established. For URLs without a port,
+// the default port of the scheme is used.
+func CheckURLReachable(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -19,15 +19,24 @@
 package reporting
 
 import (
+	"context"
 	"time"
 
 	"github.com/czcorpus/hltscl"
 )
 
 // Timescalable represents any type which is able
-// to export its data in a format required by TimescaleDB writer.
+// to export its data in a format required by TimescaleDB writer
+// and other supported outputs.
 type Timescalable interface {
 
+	// ToInfluxDB provides tags (the first returned value) and fields
+	// (the second returned value) of the record. It is a generic
+	// representation of the record used by outputs other than
+	// TimescaleDB (e.g. the InfluxDB line protocol) and also to
+	// determine the columns the record fills in.
+	ToInfluxDB() (map[string]string, map[string]any)
+
 	// ToTimescaleDB defines a method providing data
 	// to be written to a database. The first returned
 	// value is for tags, the second one for fields.
@@ -45,8 +54,75 @@ type Timescalable interface {
 	MarshalJSON() ([]byte, error)
 }
 
+// CounterRecord is an optional interface of records containing
+// differences of cumulative counters (e.g. a number of queries
+// since the previous record). Outputs distinguishing counters
+// from gauges (e.g. StatsD, OpenTelemetry) use it to pick
+// the right metric type; fields not listed are considered to be gauges.
+type CounterRecord interface {
+
+	// CounterFields provides names of fields (as returned
+	// by ToInfluxDB) containing the differences
+	CounterFields() []string
+}
+
+// CumulativeRecord is an optional interface of counter records
+// (see CounterRecord) able to provide the current values of the
+// counters instead of the differences.
+type CumulativeRecord interface {
+
+	// CumulativeValues provides the time the counters are counted
+	// from and their values. In case the values are not available,
+	// false is returned.
+	CumulativeValues() (time.Time, map[string]any, bool)
+}
+
+// BatchWriter is an optional interface of writers processing
+// all the records of a single collector run at once (e.g. to
+// publish them together).
+type BatchWriter interface {
+	WriteBatch(items []Timescalable)
+}
+
+// WriteAll passes records of a single collector run to the writer.
+// Writers implementing BatchWriter get all of them at once.
+func WriteAll(writer ReportingWriter, items []Timescalable) {
+	if bw, ok := writer.(BatchWriter); ok {
+		bw.WriteBatch(items)
+		return
+	}
+	for _, item := range items {
+		writer.Write(item)
+	}
+}
+
 type ReportingWriter interface {
 	LogErrors()
 	Write(item Timescalable)
 	AddTableWriter(tableName string)
+
+	// Flush blocks until all the items written so far are stored
+	// or until the context is done (in which case an error is returned).
+	Flush(ctx context.Context) error
+
+	// QueueStats provides state of internal queues of records
+	// waiting to be written (if the writer uses any)
+	QueueStats() []QueueStats
+
+	// Close stops accepting new items and stores the pending ones.
+	// Items which cannot be stored before the context is done are
+	// lost and an error is returned. After Close, Write must not
+	// be called.
+	Close(ctx context.Context) error
+}
+
+// BaseWriter provides no-op LogErrors and AddTableWriter for writers
+// which log write errors by themselves and need no per-table setup.
+// Such writers embed it.
+type BaseWriter struct{}
+
+func (w BaseWriter) LogErrors() {
+}
+
+func (w BaseWriter) AddTableWriter(tableName string) {
 }
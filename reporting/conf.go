@@ -19,19 +19,127 @@
 package reporting
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 
+	"github.com/czcorpus/cnc-gokit/fs"
 	"github.com/czcorpus/hltscl"
-	"github.com/rs/zerolog/log"
+	"github.com/czcorpus/mariadb-tscl/general"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+const (
+	pgDefaultPort = 5432
+
+	dfltQueueSize      = 100
+	dfltOverflowPolicy = OverflowBlock
+)
+
+// QueueConf configures queues of records waiting
+// to be written to the database (one queue per table)
+type QueueConf struct {
+
+	// Size is a maximum number of records kept in memory
+	Size int `json:"size"`
+
+	// Overflow specifies what to do when a queue is full
+	// (block, drop-oldest, drop-newest, spill)
+	Overflow OverflowPolicy `json:"overflow"`
+
+	// SpillDir is a directory for spilled records
+	// (required for the "spill" policy)
+	SpillDir string `json:"spillDir"`
+}
+
+func (conf *QueueConf) ValidateAndDefaults() error {
+	if conf.Size == 0 {
+		conf.Size = dfltQueueSize
+
+	} else if conf.Size < 0 {
+		return fmt.Errorf("invalid reporting.queue.size %d", conf.Size)
+	}
+	if conf.Overflow == "" {
+		conf.Overflow = dfltOverflowPolicy
+	}
+	if err := conf.Overflow.Validate(); err != nil {
+		return fmt.Errorf("invalid reporting.queue.overflow: %w", err)
+	}
+	if conf.Overflow == OverflowSpill {
+		if conf.SpillDir == "" {
+			return fmt.Errorf("reporting.queue.spillDir must be set for the %s policy", OverflowSpill)
+		}
+		isDir, err := fs.IsDir(conf.SpillDir)
+		if err != nil || !isDir {
+			return fmt.Errorf("reporting.queue.spillDir %s is not a directory", conf.SpillDir)
+		}
+	}
+	return nil
+}
+
+var validSSLModes = []string{
+	"disable", "allow", "prefer", "require", "verify-ca", "verify-full",
+}
+
 type Conf struct {
 	DB hltscl.PgConf `json:"db"`
+
+	// PasswordFile is a path to a file containing the database
+	// password. The file must not be readable by group or others.
+	PasswordFile string `json:"passwordFile"`
+
+	// SSLMode is a libpq-compatible sslmode (disable, allow, prefer,
+	// require, verify-ca, verify-full). By default, "prefer" is used.
+	SSLMode string `json:"sslMode"`
+
+	// SSLRootCert is a path to a CA certificate file
+	// used to verify the server's certificate
+	SSLRootCert string `json:"sslRootCert"`
+
+	// SSLCert and SSLKey specify a client certificate
+	SSLCert string `json:"sslCert"`
+	SSLKey  string `json:"sslKey"`
+
+	// ApplicationName is reported to the server so the sessions
+	// can be identified (e.g. in pg_stat_activity). By default,
+	// it is set to "mariadb-tscl/<instanceName>".
+	ApplicationName string `json:"applicationName"`
+
+	// StatementTimeout limits a duration of each statement
+	// (zero means no limit)
+	StatementTimeout general.Duration `json:"statementTimeout"`
+
+	// Layout is either "wide" (default; a table per record type
+	// with a column per field) or "narrow" (a single table with
+	// a row per metric value, see LayoutNarrow)
+	Layout string `json:"layout"`
+
+	Queue QueueConf `json:"queue"`
+}
+
+// ResolveCredentials loads the password from the password
+// file (if configured).
+func (conf *Conf) ResolveCredentials() error {
+	if conf == nil || conf.PasswordFile == "" {
+		return nil
+	}
+	if conf.DB.Passwd != "" {
+		return errors.New("both reporting.db.passwd and reporting.passwordFile are set")
+	}
+	passwd, err := general.ReadSecretFile(conf.PasswordFile)
+	if err != nil {
+		return err
+	}
+	conf.DB.Passwd = passwd
+	return nil
 }
 
 func (conf *Conf) ValidateAndDefaults() error {
 	if conf == nil {
-		log.Warn().Msg("reporting not configured, APIGuard will be writing reporting records to log")
 		return nil
 	}
 	if conf.DB.Host == "" {
@@ -40,5 +148,68 @@ func (conf *Conf) ValidateAndDefaults() error {
 	if conf.DB.Passwd == "" {
 		return fmt.Errorf("reporting set but the `password` is missing")
 	}
-	return nil
+	if conf.SSLMode != "" && !slices.Contains(validSSLModes, conf.SSLMode) {
+		return fmt.Errorf(
+			"invalid reporting.sslMode %s (expected one of %s)",
+			conf.SSLMode, strings.Join(validSSLModes, ", "),
+		)
+	}
+	if (conf.SSLCert == "") != (conf.SSLKey == "") {
+		return fmt.Errorf("reporting.sslCert and reporting.sslKey must be set together")
+	}
+	if conf.StatementTimeout < 0 {
+		return fmt.Errorf("invalid reporting.statementTimeout %s", conf.StatementTimeout)
+	}
+	if conf.Layout == "" {
+		conf.Layout = LayoutWide
+
+	} else if conf.Layout != LayoutWide && conf.Layout != LayoutNarrow {
+		return fmt.Errorf(
+			"invalid reporting.layout %s (expected %s or %s)", conf.Layout, LayoutWide, LayoutNarrow)
+	}
+	return conf.Queue.ValidateAndDefaults()
+}
+
+// ConnString creates a PostgreSQL connection URL including
+// all the configured connection options
+func (conf *Conf) ConnString() string {
+	port := conf.DB.Port
+	if port == 0 {
+		port = pgDefaultPort
+	}
+	connURL := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(conf.DB.User, conf.DB.Passwd),
+		Host:   fmt.Sprintf("%s:%d", conf.DB.Host, port),
+		Path:   "/" + conf.DB.DBName,
+	}
+	params := url.Values{}
+	if conf.SSLMode != "" {
+		params.Set("sslmode", conf.SSLMode)
+	}
+	if conf.SSLRootCert != "" {
+		params.Set("sslrootcert", conf.SSLRootCert)
+	}
+	if conf.SSLCert != "" {
+		params.Set("sslcert", conf.SSLCert)
+		params.Set("sslkey", conf.SSLKey)
+	}
+	if conf.ApplicationName != "" {
+		params.Set("application_name", conf.ApplicationName)
+	}
+	connURL.RawQuery = params.Encode()
+	return connURL.String()
+}
+
+// CreatePool creates a connection pool to the reporting database
+func CreatePool(ctx context.Context, conf *Conf) (*pgxpool.Pool, error) {
+	pgConf, err := pgxpool.ParseConfig(conf.ConnString())
+	if err != nil {
+		return nil, fmt.Errorf("invalid reporting database configuration: %w", err)
+	}
+	if conf.StatementTimeout > 0 {
+		pgConf.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(
+			conf.StatementTimeout.Duration().Milliseconds(), 10)
+	}
+	return pgxpool.NewWithConfig(ctx, pgConf)
 }
@@ -0,0 +1,94 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesink
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/general"
+)
+
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+
+	dfltMaxSizeMB      = 100
+	dfltRotateInterval = general.Duration(24 * time.Hour)
+	dfltMaxFiles       = 10
+)
+
+// Conf configures writing of records to local files. Each table
+// has its own file which is rotated once it reaches the maximum size
+// or age.
+type Conf struct {
+	Dir string `json:"dir"`
+
+	// Format is either "jsonl" (default) or "csv"
+	Format string `json:"format"`
+
+	// MaxSizeMB is a size of a file which triggers rotation
+	MaxSizeMB int `json:"maxSizeMB"`
+
+	// RotateInterval is a maximum age of a file before it
+	// is rotated (a negative value disables time based rotation)
+	RotateInterval general.Duration `json:"rotateInterval"`
+
+	// Compress specifies whether rotated files are gzipped
+	Compress bool `json:"compress"`
+
+	// MaxFiles is a number of rotated files kept for each table
+	MaxFiles int `json:"maxFiles"`
+}
+
+func (conf *Conf) extension() string {
+	if conf.Format == FormatCSV {
+		return ".csv"
+	}
+	return ".jsonl"
+}
+
+func (conf *Conf) ValidateAndDefaults() error {
+	if conf == nil {
+		return nil
+	}
+	if conf.Dir == "" {
+		return errors.New("sinks.file.dir is missing/empty")
+	}
+	if conf.Format == "" {
+		conf.Format = FormatJSONL
+
+	} else if conf.Format != FormatJSONL && conf.Format != FormatCSV {
+		return fmt.Errorf("invalid sinks.file.format %s (expected jsonl or csv)", conf.Format)
+	}
+	if conf.MaxSizeMB < 0 || conf.MaxFiles < 0 {
+		return errors.New("sinks.file.maxSizeMB and sinks.file.maxFiles must not be negative")
+	}
+	if conf.MaxSizeMB == 0 {
+		conf.MaxSizeMB = dfltMaxSizeMB
+	}
+	if conf.RotateInterval == 0 {
+		conf.RotateInterval = dfltRotateInterval
+	}
+	if conf.MaxFiles == 0 {
+		conf.MaxFiles = dfltMaxFiles
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package filesink provides a reporting writer storing records
+// in local files (JSON lines or CSV) with rotation and retention.
+// It allows running without a database and loading the data later.
+package filesink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/rs/zerolog/log"
+)
+
+// rotatedTimeFormat is used in names of rotated files
+// (it must sort chronologically). Files rotated within the same
+// second are distinguished by a zero-padded sequence number
+// so the names still sort in the order of rotation.
+const rotatedTimeFormat = "20060102-150405"
+
+// tableFile is an active file of a table
+type tableFile struct {
+	path   string
+	file   *os.File
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	header []string
+	size   int64
+
+	// opened is the time the file has been started. For
+	// reopened files, their modification time is used.
+	opened time.Time
+
+	// unknownCols contains columns missing in the CSV
+	// header (reported just once)
+	unknownCols map[string]bool
+}
+
+func (tf *tableFile) Write(p []byte) (int, error) {
+	n, err := tf.buf.Write(p)
+	tf.size += int64(n)
+	return n, err
+}
+
+func (tf *tableFile) close() error {
+	if err := tf.buf.Flush(); err != nil {
+		tf.file.Close()
+		return err
+	}
+	return tf.file.Close()
+}
+
+// Writer writes each table to its own file. Files are created
+// once the first record of a table is written.
+type Writer struct {
+	reporting.BaseWriter
+	conf *Conf
+
+	mu          sync.Mutex
+	files       map[string]*tableFile
+	writeErrors int64
+	closed      bool
+
+	// background holds running compression and cleanup jobs
+	background sync.WaitGroup
+	cleanupMu  sync.Mutex
+}
+
+func (w *Writer) Write(item reporting.Timescalable) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		log.Warn().Str("table_name", item.GetTableName()).Msg("file sink closed, record dropped")
+		return
+	}
+	if err := w.write(item); err != nil {
+		w.writeErrors++
+		log.Error().Err(err).Str("table_name", item.GetTableName()).Msg("failed to write record to file")
+	}
+}
+
+func (w *Writer) write(item reporting.Timescalable) error {
+	table := item.GetTableName()
+	tf := w.files[table]
+	if tf != nil && w.needsRotation(tf) {
+		delete(w.files, table)
+		if err := w.rotate(tf); err != nil {
+			return err
+		}
+		tf = nil
+	}
+	if tf == nil {
+		var err error
+		tf, err = w.open(table, item)
+		if err != nil {
+			return err
+		}
+		w.files[table] = tf
+	}
+	if w.conf.Format == FormatCSV {
+		if err := tf.csv.Write(w.csvRow(tf, item)); err != nil {
+			return err
+		}
+		tf.csv.Flush()
+		if err := tf.csv.Error(); err != nil {
+			return err
+		}
+
+	} else {
+		data, err := item.MarshalJSON()
+		if err != nil {
+			return fmt.Errorf("failed to encode record: %w", err)
+		}
+		if _, err := tf.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	return tf.buf.Flush()
+}
+
+func (w *Writer) needsRotation(tf *tableFile) bool {
+	if tf.size >= int64(w.conf.MaxSizeMB)*1024*1024 {
+		return true
+	}
+	return w.conf.RotateInterval > 0 && time.Since(tf.opened) >= w.conf.RotateInterval.Duration()
+}
+
+// open opens (or creates) the active file of the table. An existing
+// CSV file with a different header is rotated first.
+func (w *Writer) open(table string, item reporting.Timescalable) (*tableFile, error) {
+	path := filepath.Join(w.conf.Dir, table+w.conf.extension())
+	var header []string
+	if w.conf.Format == FormatCSV {
+		header = csvHeader(item)
+		if existing, err := readCSVHeader(path); err == nil && existing != nil && !slices.Equal(existing, header) {
+			log.Info().Str("file", path).Msg("CSV header changed, rotating the existing file")
+			if err := w.rotatePath(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open output file: %w", err)
+	}
+	opened := time.Now()
+	if info.Size() > 0 {
+		opened = info.ModTime()
+	}
+	tf := &tableFile{
+		path:        path,
+		file:        file,
+		header:      header,
+		size:        info.Size(),
+		opened:      opened,
+		unknownCols: make(map[string]bool),
+	}
+	tf.buf = bufio.NewWriter(file)
+	if w.conf.Format == FormatCSV {
+		tf.csv = csv.NewWriter(tf)
+		if info.Size() == 0 {
+			tf.csv.Write(header)
+		}
+	}
+	return tf, nil
+}
+
+func (w *Writer) rotate(tf *tableFile) error {
+	if err := tf.close(); err != nil {
+		log.Error().Err(err).Str("file", tf.path).Msg("failed to close output file")
+	}
+	return w.rotatePath(tf.path)
+}
+
+// rotatePath renames the file and schedules its compression
+// (if configured) and removal of old rotated files
+func (w *Writer) rotatePath(path string) error {
+	ext := w.conf.extension()
+	base := strings.TrimSuffix(path, ext)
+	stamp := time.Now().Format(rotatedTimeFormat)
+	rotated := fmt.Sprintf("%s-%s-%03d%s", base, stamp, 0, ext)
+	for i := 1; fileExists(rotated) || fileExists(rotated+".gz"); i++ {
+		rotated = fmt.Sprintf("%s-%s-%03d%s", base, stamp, i, ext)
+	}
+	if err := os.Rename(path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate output file: %w", err)
+	}
+	w.background.Add(1)
+	go func() {
+		defer w.background.Done()
+		w.cleanupMu.Lock()
+		defer w.cleanupMu.Unlock()
+		// the file may have been already removed as an old one
+		// by a cleanup of a later rotation
+		if w.conf.Compress && fileExists(rotated) {
+			if err := compressFile(rotated); err != nil {
+				log.Error().Err(err).Str("file", rotated).Msg("failed to compress rotated file")
+			}
+		}
+		w.removeOldFiles(base)
+	}()
+	return nil
+}
+
+// removeOldFiles keeps only the configured number
+// of the newest rotated files
+func (w *Writer) removeOldFiles(base string) {
+	matches, err := filepath.Glob(base + "-*")
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list rotated files")
+		return
+	}
+	ext := w.conf.extension()
+	rotated := slices.DeleteFunc(matches, func(p string) bool {
+		return !strings.HasSuffix(p, ext) && !strings.HasSuffix(p, ext+".gz")
+	})
+	slices.Sort(rotated)
+	for len(rotated) > w.conf.MaxFiles {
+		if err := os.Remove(rotated[0]); err != nil {
+			log.Error().Err(err).Str("file", rotated[0]).Msg("failed to remove old rotated file")
+		}
+		rotated = rotated[1:]
+	}
+}
+
+func (w *Writer) csvRow(tf *tableFile, item reporting.Timescalable) []string {
+	tags, fields := item.ToInfluxDB()
+	row := make([]string, len(tf.header))
+	for i, col := range tf.header {
+		if col == reporting.TimeColumnName {
+			row[i] = item.GetTime().Format(time.RFC3339Nano)
+
+		} else if v, ok := tags[col]; ok {
+			row[i] = v
+
+		} else if v, ok := fields[col]; ok {
+			row[i] = formatValue(v)
+		}
+	}
+	for _, cols := range []map[string]bool{keySet(tags), keySet(fields)} {
+		for col := range cols {
+			if !slices.Contains(tf.header, col) && !tf.unknownCols[col] {
+				tf.unknownCols[col] = true
+				log.Warn().Str("file", tf.path).Str("column", col).Msg("column not in CSV header, values dropped")
+			}
+		}
+	}
+	return row
+}
+
+// QueueStats provides the number of records which could not
+// be written to files
+func (w *Writer) QueueStats() []reporting.QueueStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return []reporting.QueueStats{
+		{Table: "file:" + w.conf.Dir, WriteErrors: w.writeErrors},
+	}
+}
+
+// Flush does nothing as records are written synchronously
+func (w *Writer) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close closes all the files and waits for unfinished
+// compression of rotated files
+func (w *Writer) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	var firstErr error
+	for _, tf := range w.files {
+		if err := tf.close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to close output file: %w", err)
+		}
+	}
+	w.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if firstErr == nil {
+			firstErr = fmt.Errorf("failed to finish compression of rotated files: %w", ctx.Err())
+		}
+	}
+	return firstErr
+}
+
+// NewWriter creates a writer. The configuration
+// is expected to be validated.
+func NewWriter(conf *Conf) (*Writer, error) {
+	if err := os.MkdirAll(conf.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	return &Writer{
+		conf:  conf,
+		files: make(map[string]*tableFile),
+	}, nil
+}
+
+// CheckDir tests whether files can be created in the output directory
+func CheckDir(conf *Conf) error {
+	if err := os.MkdirAll(conf.Dir, 0o755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(conf.Dir, ".check-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// ----
+
+// csvHeader provides columns of the record's table. It contains columns
+// of all the record types registered for the table (so the header does
+// not depend on which record comes first), sorted by name, with the
+// time column first.
+func csvHeader(item reporting.Timescalable) []string {
+	cols := reporting.ExpectedColumns(item.GetTableName())
+	tags, fields := item.ToInfluxDB()
+	for _, keys := range []map[string]bool{keySet(tags), keySet(fields)} {
+		for k := range keys {
+			if !slices.Contains(cols, k) {
+				cols = append(cols, k)
+			}
+		}
+	}
+	cols = slices.DeleteFunc(cols, func(c string) bool { return c == reporting.TimeColumnName })
+	slices.Sort(cols)
+	return append([]string{reporting.TimeColumnName}, cols...)
+}
+
+func readCSVHeader(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header, err := csv.NewReader(file).Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	return header, err
+}
+
+func keySet[T any](m map[string]T) map[string]bool {
+	ans := make(map[string]bool, len(m))
+	for k := range m {
+		ans[k] = true
+	}
+	return ans
+}
+
+func formatValue(v any) string {
+	switch tv := v.(type) {
+	case float64:
+		return strconv.FormatFloat(tv, 'f', -1, 64)
+	default:
+		return fmt.Sprint(tv)
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// compressFile replaces the file with its gzipped version
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
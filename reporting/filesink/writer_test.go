@@ -0,0 +1,166 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesink
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/general"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWriter(t *testing.T, conf *Conf) *Writer {
+	conf.Dir = t.TempDir()
+	require.NoError(t, conf.ValidateAndDefaults())
+	w, err := NewWriter(conf)
+	require.NoError(t, err)
+	return w
+}
+
+func statusRecord(comSelect int) *reporting.ConnectionsStatus {
+	return &reporting.ConnectionsStatus{
+		Created:  time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC),
+		Instance: "test",
+		Status:   db.Status{ComSelect: comSelect},
+	}
+}
+
+func readGzip(t *testing.T, path string) string {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	r, err := gzip.NewReader(file)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestWriteJSONL(t *testing.T) {
+	w := newTestWriter(t, &Conf{})
+	w.Write(statusRecord(1))
+	w.Write(statusRecord(2))
+	require.NoError(t, w.Close(context.Background()))
+
+	data, err := os.ReadFile(filepath.Join(w.conf.Dir, reporting.MariaDBTSCLStatusMonitoringTable+".jsonl"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	var rec map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &rec))
+	assert.Equal(t, float64(2), rec["comSelect"])
+	assert.Equal(t, "test", rec["instance"])
+}
+
+func TestWriteCSVStableHeader(t *testing.T) {
+	w := newTestWriter(t, &Conf{Format: FormatCSV})
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	w.Write(&reporting.ProcessSelfStatus{Created: now, Instance: "test", Goroutines: 12})
+	w.Write(&reporting.CollectorSelfStatus{Created: now, Instance: "test", Collector: "global_status", Collections: 6})
+	require.NoError(t, w.Close(context.Background()))
+
+	file, err := os.Open(filepath.Join(w.conf.Dir, reporting.MariaDBTSCLSelfTable+".csv"))
+	require.NoError(t, err)
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	header := rows[0]
+	assert.Equal(t, reporting.TimeColumnName, header[0])
+	// columns of both record types are present regardless of the first record
+	assert.Contains(t, header, "goroutines")
+	assert.Contains(t, header, "collections")
+	col := func(row []string, name string) string {
+		for i, h := range header {
+			if h == name {
+				return row[i]
+			}
+		}
+		return "<missing>"
+	}
+	assert.Equal(t, "12", col(rows[1], "goroutines"))
+	assert.Equal(t, "", col(rows[1], "collections"))
+	assert.Equal(t, "6", col(rows[2], "collections"))
+	assert.Equal(t, "global_status", col(rows[2], "collector"))
+	assert.Equal(t, "2024-05-01T08:00:00Z", col(rows[2], "time"))
+}
+
+func TestRotationAndRetention(t *testing.T) {
+	w := newTestWriter(t, &Conf{Compress: true, MaxFiles: 2, RotateInterval: general.Duration(-1)})
+	// rotate after every record
+	w.conf.MaxSizeMB = 0
+	for i := 0; i < 5; i++ {
+		w.Write(statusRecord(i))
+	}
+	require.NoError(t, w.Close(context.Background()))
+
+	base := filepath.Join(w.conf.Dir, reporting.MariaDBTSCLStatusMonitoringTable)
+	rotated, err := filepath.Glob(base + "-*")
+	require.NoError(t, err)
+	require.Len(t, rotated, 2)
+	// the newest files are kept (all of them rotated within
+	// a single second so only the sequence tells their order)
+	slices.Sort(rotated)
+	for i, p := range rotated {
+		assert.True(t, strings.HasSuffix(p, ".jsonl.gz"), p)
+		assert.Contains(t, readGzip(t, p), fmt.Sprintf(`"comSelect":%d`, i+2))
+	}
+	data, err := os.ReadFile(base + ".jsonl")
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"comSelect":4`)
+	assert.Zero(t, w.QueueStats()[0].WriteErrors)
+}
+
+func TestReopenedFileKeepsRotationTime(t *testing.T) {
+	w := newTestWriter(t, &Conf{RotateInterval: general.Duration(time.Hour)})
+	path := filepath.Join(w.conf.Dir, reporting.MariaDBTSCLStatusMonitoringTable+".jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{}\n"), 0o644))
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(path, old, old))
+
+	w.Write(statusRecord(1))
+	w.Write(statusRecord(2))
+	require.NoError(t, w.Close(context.Background()))
+
+	// the file is too old so the second record starts a new one
+	rotated, err := filepath.Glob(strings.TrimSuffix(path, ".jsonl") + "-*")
+	require.NoError(t, err)
+	require.Len(t, rotated, 1)
+	data, err := os.ReadFile(rotated[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"comSelect":1`)
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"comSelect":2`)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+}
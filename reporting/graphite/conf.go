@@ -0,0 +1,145 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/general"
+)
+
+const (
+	// ProtocolStatsD sends metrics as StatsD gauges and counters over UDP
+	ProtocolStatsD = "statsd"
+
+	// ProtocolGraphite sends metrics using the Graphite plaintext
+	// protocol over TCP
+	ProtocolGraphite = "graphite"
+
+	dfltStatsDAddress   = "localhost:8125"
+	dfltGraphiteAddress = "localhost:2003"
+	dfltPathTemplate    = "mariadb.{instance}.{table}.{collector}.{field}"
+
+	// dfltMaxPacketSize fits into a single Ethernet frame
+	// (1500 bytes MTU minus IP and UDP headers with some reserve)
+	dfltMaxPacketSize = 1432
+
+	dfltFlushInterval  = general.Duration(time.Second)
+	dfltBufferSize     = 10000
+	dfltTimeout        = general.Duration(5 * time.Second)
+	dfltReconnectDelay = general.Duration(5 * time.Second)
+)
+
+// Conf configures sending of numeric fields of records
+// to StatsD or Graphite
+type Conf struct {
+
+	// Protocol is either "statsd" or "graphite"
+	Protocol string `json:"protocol"`
+
+	// Address is host:port of the StatsD (default localhost:8125)
+	// or Graphite (default localhost:2003) server
+	Address string `json:"address"`
+
+	// PathTemplate specifies metric paths. The {table} and {field}
+	// placeholders are replaced with a table and field name, other
+	// placeholders (e.g. {instance}) with values of record tags.
+	// Path components which end up empty (e.g. a tag a record does
+	// not have) are omitted.
+	PathTemplate string `json:"pathTemplate"`
+
+	// MaxPacketSize is a maximum size of a StatsD datagram.
+	// Larger values should be used only if the network MTU
+	// allows it.
+	MaxPacketSize int `json:"maxPacketSize"`
+
+	// FlushInterval specifies how often pending metrics are sent
+	FlushInterval general.Duration `json:"flushInterval"`
+
+	// BufferSize is a maximum number of pending metric lines.
+	// In case the Graphite server is unavailable for a long time,
+	// the oldest lines are dropped.
+	BufferSize int `json:"bufferSize"`
+
+	// Timeout limits connecting to the Graphite server
+	// and writing to it
+	Timeout general.Duration `json:"timeout"`
+
+	// ReconnectDelay is a minimum time between attempts
+	// to reconnect to the Graphite server
+	ReconnectDelay general.Duration `json:"reconnectDelay"`
+}
+
+func (conf *Conf) ValidateAndDefaults() error {
+	if conf == nil {
+		return nil
+	}
+	switch conf.Protocol {
+	case ProtocolStatsD:
+		if conf.Address == "" {
+			conf.Address = dfltStatsDAddress
+		}
+	case ProtocolGraphite:
+		if conf.Address == "" {
+			conf.Address = dfltGraphiteAddress
+		}
+	case "":
+		return errors.New("sinks.graphite.protocol is missing/empty")
+	default:
+		return fmt.Errorf(
+			"invalid sinks.graphite.protocol %s (must be %s or %s)",
+			conf.Protocol, ProtocolStatsD, ProtocolGraphite,
+		)
+	}
+	if _, _, err := net.SplitHostPort(conf.Address); err != nil {
+		return fmt.Errorf("invalid sinks.graphite.address: %w", err)
+	}
+	if conf.PathTemplate == "" {
+		conf.PathTemplate = dfltPathTemplate
+	}
+	if !strings.Contains(conf.PathTemplate, "{field}") {
+		return errors.New("sinks.graphite.pathTemplate must contain the {field} placeholder")
+	}
+	if conf.MaxPacketSize < 0 || conf.BufferSize < 0 {
+		return errors.New("sinks.graphite.maxPacketSize and bufferSize must not be negative")
+	}
+	if conf.FlushInterval < 0 || conf.Timeout < 0 || conf.ReconnectDelay < 0 {
+		return errors.New("sinks.graphite.flushInterval, timeout and reconnectDelay must not be negative")
+	}
+	if conf.MaxPacketSize == 0 {
+		conf.MaxPacketSize = dfltMaxPacketSize
+	}
+	if conf.FlushInterval == 0 {
+		conf.FlushInterval = dfltFlushInterval
+	}
+	if conf.BufferSize == 0 {
+		conf.BufferSize = dfltBufferSize
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = dfltTimeout
+	}
+	if conf.ReconnectDelay == 0 {
+		conf.ReconnectDelay = dfltReconnectDelay
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package graphite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// tcpChunkSize is a maximum number of bytes written
+// to a Graphite connection at once
+const tcpChunkSize = 64 * 1024
+
+var errReconnectDelay = errors.New("waiting before reconnecting")
+
+// output is a destination of encoded metric lines
+type output interface {
+	write(data []byte) error
+	close() error
+}
+
+// ----
+
+// udpOutput sends each chunk of lines as a single datagram
+type udpOutput struct {
+	conn net.Conn
+}
+
+func (o *udpOutput) write(data []byte) error {
+	_, err := o.conn.Write(data)
+	return err
+}
+
+func (o *udpOutput) close() error {
+	return o.conn.Close()
+}
+
+// ----
+
+// tcpOutput writes lines to a connection which is (re)opened lazily.
+// After a failed connection attempt, the next one is made
+// no sooner than after the reconnect delay.
+type tcpOutput struct {
+	address        string
+	timeout        time.Duration
+	reconnectDelay time.Duration
+	conn           net.Conn
+	nextDial       time.Time
+}
+
+func (o *tcpOutput) connect() error {
+	if o.conn != nil {
+		return nil
+	}
+	if time.Now().Before(o.nextDial) {
+		return errReconnectDelay
+	}
+	conn, err := net.DialTimeout("tcp", o.address, o.timeout)
+	if err != nil {
+		o.nextDial = time.Now().Add(o.reconnectDelay)
+		return err
+	}
+	o.conn = conn
+	return nil
+}
+
+func (o *tcpOutput) write(data []byte) error {
+	if err := o.connect(); err != nil {
+		return err
+	}
+	o.conn.SetWriteDeadline(time.Now().Add(o.timeout))
+	if _, err := o.conn.Write(data); err != nil {
+		o.conn.Close()
+		o.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (o *tcpOutput) close() error {
+	if o.conn == nil {
+		return nil
+	}
+	err := o.conn.Close()
+	o.conn = nil
+	return err
+}
+
+// ----
+
+func openOutput(conf *Conf) (output, error) {
+	if conf.Protocol == ProtocolGraphite {
+		return &tcpOutput{
+			address:        conf.Address,
+			timeout:        conf.Timeout.Duration(),
+			reconnectDelay: conf.ReconnectDelay.Duration(),
+		}, nil
+	}
+	conn, err := net.Dial("udp", conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open StatsD output: %w", err)
+	}
+	return &udpOutput{conn: conn}, nil
+}
+
+// CheckAddress tests whether the server is usable. For Graphite,
+// it tests whether a connection can be opened, for StatsD (which
+// uses UDP and cannot be tested reliably), it tests whether
+// the address can be resolved.
+func CheckAddress(ctx context.Context, conf *Conf) error {
+	if conf.Protocol == ProtocolGraphite {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", conf.Address)
+		if err != nil {
+			return fmt.Errorf("Graphite server unreachable: %w", err)
+		}
+		return conn.Close()
+	}
+	_, err := net.DefaultResolver.LookupHost(ctx, hostOf(conf.Address))
+	return err
+}
+
+func hostOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
@@ -0,0 +1,201 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package graphite provides a reporting writer sending numeric
+// fields of records to StatsD (as gauges and counters over UDP)
+// or to Graphite (using the plaintext protocol over TCP).
+package graphite
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/czcorpus/mariadb-tscl/reporting"
+)
+
+var placeholderRegexp = regexp.MustCompile(`\{(\w+)\}`)
+
+// Writer encodes records into metric lines and sends them
+// in the configured interval (see reporting.BatchQueue). StatsD
+// lines are packed into datagrams not exceeding the maximum packet
+// size and dropped if they cannot be sent, Graphite lines which
+// cannot be sent are kept (up to the configured buffer size)
+// for the next attempt.
+type Writer struct {
+	reporting.BaseWriter
+	conf  *Conf
+	out   output
+	queue *reporting.BatchQueue[string]
+}
+
+func (w *Writer) Write(item reporting.Timescalable) {
+	w.queue.Push(w.encode(item)...)
+}
+
+// encode creates a metric line for each numeric field of the record
+func (w *Writer) encode(item reporting.Timescalable) []string {
+	tags, fields := item.ToInfluxDB()
+	var counters []string
+	if cr, ok := item.(reporting.CounterRecord); ok {
+		counters = cr.CounterFields()
+	}
+	ans := make([]string, 0, len(fields))
+	for _, field := range slices.Sorted(maps.Keys(fields)) {
+		num, ok := reporting.NumericValue(fields[field])
+		if !ok {
+			continue
+		}
+		value := strconv.FormatFloat(num, 'f', -1, 64)
+		path := metricPath(w.conf.PathTemplate, item.GetTableName(), field, tags)
+		if w.conf.Protocol == ProtocolGraphite {
+			ans = append(ans, fmt.Sprintf("%s %s %d", path, value, item.GetTime().Unix()))
+
+		} else if slices.Contains(counters, field) {
+			ans = append(ans, fmt.Sprintf("%s:%s|c", path, value))
+
+		} else if strings.HasPrefix(value, "-") {
+			// a signed gauge value would be interpreted as a change
+			// of the current value so the gauge must be reset first
+			ans = append(ans, fmt.Sprintf("%s:0|g\n%s:%s|g", path, path, value))
+
+		} else {
+			ans = append(ans, fmt.Sprintf("%s:%s|g", path, value))
+		}
+	}
+	return ans
+}
+
+// send writes a chunk of lines (see nextChunk). Lines
+// of failed StatsD datagrams are not sent again.
+func (w *Writer) send(ctx context.Context, lines []string) error {
+	_, data := nextChunk(lines, w.chunkSize())
+	err := w.out.write(data)
+	if err != nil && w.conf.Protocol != ProtocolGraphite {
+		return &reporting.PermanentError{Err: err}
+	}
+	return err
+}
+
+func (w *Writer) chunkSize() int {
+	if w.conf.Protocol == ProtocolGraphite {
+		return tcpChunkSize
+	}
+	return w.conf.MaxPacketSize
+}
+
+// QueueStats provides state of the buffer of pending lines
+func (w *Writer) QueueStats() []reporting.QueueStats {
+	return []reporting.QueueStats{w.queue.Stats()}
+}
+
+// Flush requests sending of all the pending lines and waits until
+// they are sent or until the context is done.
+func (w *Writer) Flush(ctx context.Context) error {
+	return w.queue.Flush(ctx)
+}
+
+// Close stops accepting new records, sends the pending ones
+// and closes the connection. If the context is done before that,
+// an error is returned (a write in progress is still limited
+// by the configured timeout).
+func (w *Writer) Close(ctx context.Context) error {
+	err := w.queue.Close(ctx)
+	w.out.close()
+	return err
+}
+
+// NewWriter creates a writer and starts its background loop.
+// The configuration is expected to be validated.
+func NewWriter(conf *Conf) (*Writer, error) {
+	out, err := openOutput(conf)
+	if err != nil {
+		return nil, err
+	}
+	ans := &Writer{conf: conf, out: out}
+	ans.queue = reporting.NewBatchQueue(
+		reporting.BatchQueueConf[string]{
+			Name:          conf.Protocol + ":" + conf.Address,
+			BufferSize:    conf.BufferSize,
+			FlushInterval: conf.FlushInterval.Duration(),
+			NextBatch: func(pending []string) int {
+				n, _ := nextChunk(pending, ans.chunkSize())
+				return n
+			},
+		},
+		ans.send,
+	)
+	return ans, nil
+}
+
+// ----
+
+// nextChunk joins the leading lines so the result does not exceed
+// the size limit (a single longer line is returned as it is).
+// The number of used lines is returned along with the data.
+func nextChunk(lines []string, limit int) (int, []byte) {
+	var buf []byte
+	n := 0
+	for _, line := range lines {
+		if n > 0 && len(buf)+len(line)+1 > limit {
+			break
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+		n++
+	}
+	return n, buf
+}
+
+// metricPath fills the path template. Empty path
+// components are omitted.
+func metricPath(template, table, field string, tags map[string]string) string {
+	components := strings.Split(template, ".")
+	ans := make([]string, 0, len(components))
+	for _, c := range components {
+		c = placeholderRegexp.ReplaceAllStringFunc(c, func(ph string) string {
+			switch name := ph[1 : len(ph)-1]; name {
+			case "table":
+				return sanitize(table)
+			case "field":
+				return sanitize(field)
+			default:
+				return sanitize(tags[name])
+			}
+		})
+		if c != "" {
+			ans = append(ans, c)
+		}
+	}
+	return strings.Join(ans, ".")
+}
+
+// sanitize replaces characters with a special meaning
+// in metric paths with underscores
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
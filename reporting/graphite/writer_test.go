@@ -0,0 +1,139 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/general"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRecord() *reporting.ConnectionsStatus {
+	return &reporting.ConnectionsStatus{
+		Created:    time.Unix(1700000000, 0),
+		Instance:   "db.1",
+		IntervalMs: 10000,
+		Status:     db.Status{ComSelect: 12, ThreadsConnected: 4},
+	}
+}
+
+func TestMetricPath(t *testing.T) {
+	tags := map[string]string{"instance": "db.1"}
+	assert.Equal(
+		t,
+		"mariadb.db_1.status.com_select",
+		metricPath("mariadb.{instance}.{table}.{collector}.{field}", "status", "com_select", tags),
+	)
+	assert.Equal(t, "x.db_1-com_select", metricPath("x.{instance}-{field}", "status", "com_select", tags))
+}
+
+func TestStatsDPackets(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	conf := &Conf{Protocol: ProtocolStatsD, Address: pc.LocalAddr().String(), MaxPacketSize: 200}
+	require.NoError(t, conf.ValidateAndDefaults())
+	w, err := NewWriter(conf)
+	require.NoError(t, err)
+	w.Write(testRecord())
+	require.NoError(t, w.Close(context.Background()))
+
+	var lines []string
+	buf := make([]byte, 65536)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	for len(lines) < 19 {
+		n, _, err := pc.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, n, 200)
+		lines = append(lines, strings.Split(strings.TrimSuffix(string(buf[:n]), "\n"), "\n")...)
+	}
+	prefix := "mariadb.db_1.mariadb_tscl_status_monitoring."
+	assert.Contains(t, lines, prefix+"com_select:12|c")
+	assert.Contains(t, lines, prefix+"threads_connected:4|g")
+	assert.Contains(t, lines, prefix+"interval_ms:10000|g")
+}
+
+func TestGraphiteReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	conf := &Conf{
+		Protocol:       ProtocolGraphite,
+		Address:        addr,
+		FlushInterval:  general.Duration(time.Hour),
+		ReconnectDelay: general.Duration(10 * time.Millisecond),
+	}
+	require.NoError(t, conf.ValidateAndDefaults())
+	w, err := NewWriter(conf)
+	require.NoError(t, err)
+	w.Write(testRecord())
+
+	// the server is not running so the lines must stay pending
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, w.Flush(ctx))
+	assert.Equal(t, 19, w.QueueStats()[0].Depth)
+
+	ln, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	defer ln.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for len(lines) < 19 && scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+	for i := 0; ; i++ {
+		// repeat flushing until the reconnect delay passes
+		require.Less(t, i, 20, "lines not sent after reconnecting")
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		err := w.Flush(ctx)
+		cancel()
+		if err == nil {
+			break
+		}
+	}
+	require.NoError(t, w.Close(context.Background()))
+	select {
+	case lines := <-received:
+		assert.Contains(t, lines, "mariadb.db_1.mariadb_tscl_status_monitoring.com_select 12 1700000000")
+	case <-time.After(time.Second):
+		t.Fatal("no lines received")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// statusGaugeColumns are columns of the status table
+// which do not contain differences but actual values
+var statusGaugeColumns = map[string]bool{
+	"threads_connected":    true,
+	"max_used_connections": true,
+}
+
+// LoadStatusHistory aggregates status records of the instance
+// stored since the specified time. For counters, the sum of all
+// the differences is returned, for gauges, the maximum value is
+// returned (the sum of `interval_ms` gives the time covered
+// by the records). Keys are column names (which are the same as lowercased
+// MariaDB status variable names). The second returned value
+// is the number of aggregated records.
+func LoadStatusHistory(
+	ctx context.Context,
+	conn *pgxpool.Pool,
+	instance string,
+	since time.Time,
+) (map[string]int64, int, error) {
+	_, fields := (&ConnectionsStatus{}).ToInfluxDB()
+	columns := slices.Sorted(maps.Keys(fields))
+	exprs := make([]string, len(columns))
+	for i, col := range columns {
+		if statusGaugeColumns[col] {
+			exprs[i] = fmt.Sprintf("COALESCE(MAX(%s), 0)::bigint", col)
+
+		} else {
+			exprs[i] = fmt.Sprintf("COALESCE(SUM(%s), 0)::bigint", col)
+		}
+	}
+	sql := fmt.Sprintf(
+		"SELECT COUNT(*), %s FROM %s WHERE instance = $1 AND %s >= $2",
+		strings.Join(exprs, ", "), MariaDBTSCLStatusMonitoringTable, TimeColumnName,
+	)
+	var numRecords int
+	values := make([]int64, len(columns))
+	dest := make([]any, len(columns)+1)
+	dest[0] = &numRecords
+	for i := range values {
+		dest[i+1] = &values[i]
+	}
+	if err := conn.QueryRow(ctx, sql, instance, since).Scan(dest...); err != nil {
+		return nil, 0, fmt.Errorf("failed to load status history: %w", err)
+	}
+	ans := make(map[string]int64, len(columns))
+	for i, col := range columns {
+		ans[col] = values[i]
+	}
+	return ans, numRecords, nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/general"
+)
+
+const (
+	// OutputStdout is a special output value for writing to stdout
+	OutputStdout = "stdout"
+
+	dfltBatchSize     = 500
+	dfltFlushInterval = general.Duration(10 * time.Second)
+	dfltBufferSize    = 10000
+	dfltMaxRetries    = 3
+	dfltRetryDelay    = general.Duration(time.Second)
+	dfltTimeout       = general.Duration(10 * time.Second)
+)
+
+// Conf configures writing of records in the InfluxDB line protocol
+type Conf struct {
+
+	// Output is either "stdout", a path to a file (lines are appended)
+	// or a URL of an HTTP write endpoint (e.g. InfluxDB's
+	// http://host:8086/api/v2/write?org=my-org&bucket=my-bucket
+	// or VictoriaMetrics' http://host:8428/write)
+	Output string `json:"output"`
+
+	// Token is sent in the Authorization header ("Token <token>")
+	// of HTTP requests
+	Token     string `json:"token"`
+	TokenFile string `json:"tokenFile"`
+
+	// BatchSize is a maximum number of lines written at once
+	BatchSize int `json:"batchSize"`
+
+	// FlushInterval specifies how often pending lines are written
+	// (a full batch is written immediately)
+	FlushInterval general.Duration `json:"flushInterval"`
+
+	// BufferSize is a maximum number of pending lines. In case
+	// the output is unavailable for a long time, the oldest lines
+	// are dropped.
+	BufferSize int `json:"bufferSize"`
+
+	// MaxRetries is a number of repeated attempts to write a batch
+	// before it is postponed to the next flush
+	MaxRetries int              `json:"maxRetries"`
+	RetryDelay general.Duration `json:"retryDelay"`
+
+	// Timeout limits a single HTTP request
+	Timeout general.Duration `json:"timeout"`
+}
+
+// IsHTTP tests whether the output is an HTTP(S) endpoint
+func (conf *Conf) IsHTTP() bool {
+	return strings.HasPrefix(conf.Output, "http://") || strings.HasPrefix(conf.Output, "https://")
+}
+
+func (conf *Conf) ResolveCredentials() error {
+	if conf == nil || conf.TokenFile == "" {
+		return nil
+	}
+	if conf.Token != "" {
+		return errors.New("both sinks.influx.token and sinks.influx.tokenFile are set")
+	}
+	token, err := general.ReadSecretFile(conf.TokenFile)
+	if err != nil {
+		return err
+	}
+	conf.Token = token
+	return nil
+}
+
+func (conf *Conf) ValidateAndDefaults() error {
+	if conf == nil {
+		return nil
+	}
+	if conf.Output == "" {
+		return errors.New("sinks.influx.output is missing/empty")
+	}
+	if conf.IsHTTP() {
+		if _, err := url.Parse(conf.Output); err != nil {
+			return fmt.Errorf("invalid sinks.influx.output: %w", err)
+		}
+
+	} else if conf.Token != "" {
+		return errors.New("sinks.influx.token can be used only with an HTTP output")
+	}
+	if conf.BatchSize < 0 || conf.BufferSize < 0 || conf.MaxRetries < 0 {
+		return errors.New("sinks.influx.batchSize, bufferSize and maxRetries must not be negative")
+	}
+	if conf.FlushInterval < 0 || conf.RetryDelay < 0 || conf.Timeout < 0 {
+		return errors.New("sinks.influx.flushInterval, retryDelay and timeout must not be negative")
+	}
+	if conf.BatchSize == 0 {
+		conf.BatchSize = dfltBatchSize
+	}
+	if conf.FlushInterval == 0 {
+		conf.FlushInterval = dfltFlushInterval
+	}
+	if conf.BufferSize == 0 {
+		conf.BufferSize = dfltBufferSize
+	}
+	if conf.BufferSize < conf.BatchSize {
+		return fmt.Errorf("sinks.influx.bufferSize must not be smaller than batchSize (%d)", conf.BatchSize)
+	}
+	if conf.MaxRetries == 0 {
+		conf.MaxRetries = dfltMaxRetries
+	}
+	if conf.RetryDelay == 0 {
+		conf.RetryDelay = dfltRetryDelay
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = dfltTimeout
+	}
+	return nil
+}
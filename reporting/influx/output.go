@@ -0,0 +1,146 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package influx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/czcorpus/mariadb-tscl/reporting"
+)
+
+// output is a destination of line protocol batches
+type output interface {
+	write(ctx context.Context, lines []string) error
+	close() error
+}
+
+func joinLines(lines []string) []byte {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// ----
+
+// streamOutput writes to a file or to stdout
+type streamOutput struct {
+	w     io.Writer
+	file  *os.File
+	owned bool
+}
+
+func (o *streamOutput) write(ctx context.Context, lines []string) error {
+	_, err := o.w.Write(joinLines(lines))
+	return err
+}
+
+func (o *streamOutput) close() error {
+	if o.owned {
+		return o.file.Close()
+	}
+	return nil
+}
+
+// ----
+
+type httpOutput struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (o *httpOutput) write(ctx context.Context, lines []string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(joinLines(lines)))
+	if err != nil {
+		return &reporting.PermanentError{Err: err}
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if o.token != "" {
+		req.Header.Set("Authorization", "Token "+o.token)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("write endpoint responded with %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return &reporting.PermanentError{Err: err}
+	}
+	return err
+}
+
+func (o *httpOutput) close() error {
+	o.client.CloseIdleConnections()
+	return nil
+}
+
+// ----
+
+func openOutput(conf *Conf) (output, error) {
+	if conf.Output == OutputStdout {
+		return &streamOutput{w: os.Stdout}, nil
+
+	} else if conf.IsHTTP() {
+		return &httpOutput{
+			url:    conf.Output,
+			token:  conf.Token,
+			client: &http.Client{Timeout: conf.Timeout.Duration()},
+		}, nil
+	}
+	file, err := os.OpenFile(conf.Output, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open line protocol output: %w", err)
+	}
+	return &streamOutput{w: file, file: file, owned: true}, nil
+}
+
+// CheckOutput tests whether the output is usable. For files, it tests
+// whether the file can be opened for writing, for HTTP endpoints, it
+// tests whether the server is reachable.
+func CheckOutput(ctx context.Context, conf *Conf) error {
+	if conf.Output == OutputStdout {
+		return nil
+
+	} else if conf.IsHTTP() {
+		if err := reporting.CheckURLReachable(ctx, conf.Output); err != nil {
+			return fmt.Errorf("write endpoint unreachable: %w", err)
+		}
+		return nil
+	}
+	file, err := os.OpenFile(conf.Output, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package influx provides a reporting writer producing records
+// in the InfluxDB line protocol (usable also with VictoriaMetrics
+// and other compatible databases). Table names are used as
+// measurement names.
+package influx
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/czcorpus/mariadb-tscl/reporting"
+)
+
+// Writer batches records encoded in the line protocol and writes
+// them to the configured output (see reporting.BatchQueue)
+type Writer struct {
+	reporting.BaseWriter
+	out   output
+	queue *reporting.BatchQueue[string]
+}
+
+func (w *Writer) Write(item reporting.Timescalable) {
+	tags, fields := item.ToInfluxDB()
+	w.queue.Push(reporting.FormatLineProtocol(item.GetTableName(), item.GetTime(), tags, fields))
+}
+
+// QueueStats provides state of the buffer of pending lines
+func (w *Writer) QueueStats() []reporting.QueueStats {
+	return []reporting.QueueStats{w.queue.Stats()}
+}
+
+// Flush requests writing of all the pending lines and waits until
+// they are written or until the context is done
+func (w *Writer) Flush(ctx context.Context) error {
+	return w.queue.Flush(ctx)
+}
+
+// Close stops accepting new records, writes the pending ones
+// and closes the output
+func (w *Writer) Close(ctx context.Context) error {
+	err := w.queue.Close(ctx)
+	if cerr := w.out.close(); cerr != nil && err == nil {
+		err = fmt.Errorf("failed to close line protocol output: %w", cerr)
+	}
+	return err
+}
+
+// NewWriter creates a writer and starts its background loop.
+// The configuration is expected to be validated.
+func NewWriter(conf *Conf) (*Writer, error) {
+	out, err := openOutput(conf)
+	if err != nil {
+		return nil, err
+	}
+	queueConf := reporting.BatchQueueConf[string]{
+		Name:          "influx:" + conf.Output,
+		BatchSize:     conf.BatchSize,
+		BufferSize:    conf.BufferSize,
+		FlushInterval: conf.FlushInterval.Duration(),
+		MaxRetries:    conf.MaxRetries,
+		RetryDelay:    conf.RetryDelay.Duration(),
+	}
+	return &Writer{out: out, queue: reporting.NewBatchQueue(queueConf, out.write)}, nil
+}
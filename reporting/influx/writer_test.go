@@ -0,0 +1,168 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package influx
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/general"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRecord(comSelect int) *reporting.ConnectionsStatus {
+	return &reporting.ConnectionsStatus{
+		Created:  time.Unix(1700000000, 0),
+		Instance: "db 1",
+		Status:   db.Status{ComSelect: comSelect},
+	}
+}
+
+func testConf(output string) *Conf {
+	conf := &Conf{
+		Output:        output,
+		BatchSize:     2,
+		FlushInterval: general.Duration(time.Hour),
+		RetryDelay:    general.Duration(time.Millisecond),
+	}
+	if err := conf.ValidateAndDefaults(); err != nil {
+		panic(err)
+	}
+	return conf
+}
+
+type endpoint struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+	auth     string
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	e.auth = r.Header.Get("Authorization")
+	status := http.StatusNoContent
+	if len(e.statuses) > 0 {
+		status = e.statuses[0]
+		e.statuses = e.statuses[1:]
+	}
+	if status == http.StatusNoContent {
+		e.bodies = append(e.bodies, string(body))
+	}
+	w.WriteHeader(status)
+}
+
+func (e *endpoint) received() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string{}, e.bodies...)
+}
+
+func TestWriteHTTPBatches(t *testing.T) {
+	ep := &endpoint{}
+	server := httptest.NewServer(ep)
+	defer server.Close()
+	conf := testConf(server.URL + "/write")
+	conf.Token = "secret"
+	w, err := NewWriter(conf)
+	require.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		w.Write(testRecord(i))
+	}
+	require.NoError(t, w.Close(context.Background()))
+
+	bodies := ep.received()
+	require.Len(t, bodies, 2)
+	assert.Equal(t, 2, strings.Count(bodies[0], "\n"))
+	assert.Equal(t, 1, strings.Count(bodies[1], "\n"))
+	assert.True(t, strings.HasPrefix(
+		bodies[0], "mariadb_tscl_status_monitoring,instance=db\\ 1 "))
+	assert.Contains(t, bodies[0], "com_select=1i")
+	assert.Contains(t, bodies[1], "com_select=3i")
+	assert.Equal(t, "Token secret", ep.auth)
+}
+
+func TestWriteHTTPRetry(t *testing.T) {
+	ep := &endpoint{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	server := httptest.NewServer(ep)
+	defer server.Close()
+	w, err := NewWriter(testConf(server.URL))
+	require.NoError(t, err)
+	w.Write(testRecord(1))
+	require.NoError(t, w.Flush(context.Background()))
+	assert.Len(t, ep.received(), 1)
+	assert.Zero(t, w.QueueStats()[0].WriteErrors)
+	require.NoError(t, w.Close(context.Background()))
+}
+
+func TestWriteHTTPRejected(t *testing.T) {
+	ep := &endpoint{statuses: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(ep)
+	defer server.Close()
+	w, err := NewWriter(testConf(server.URL))
+	require.NoError(t, err)
+	w.Write(testRecord(1))
+	w.Write(testRecord(2))
+	require.NoError(t, w.Flush(context.Background()))
+	assert.Empty(t, ep.received())
+	assert.Equal(t, int64(2), w.QueueStats()[0].WriteErrors)
+	require.NoError(t, w.Close(context.Background()))
+}
+
+func TestWriteUnavailableKeepsLines(t *testing.T) {
+	ep := &endpoint{statuses: []int{500, 500, 500, 500}}
+	server := httptest.NewServer(ep)
+	defer server.Close()
+	w, err := NewWriter(testConf(server.URL))
+	require.NoError(t, err)
+	w.Write(testRecord(1))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	// all the attempts fail, the line stays pending
+	assert.Error(t, w.Flush(ctx))
+	assert.Equal(t, 1, w.QueueStats()[0].Depth)
+	// the endpoint is available again
+	require.NoError(t, w.Close(context.Background()))
+	assert.Len(t, ep.received(), 1)
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.lp")
+	w, err := NewWriter(testConf(path))
+	require.NoError(t, err)
+	w.Write(testRecord(5))
+	require.NoError(t, w.Close(context.Background()))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+	assert.True(t, strings.HasSuffix(string(data), " 1700000000000000000\n"))
+}
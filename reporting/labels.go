@@ -0,0 +1,147 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package reporting
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"time"
+
+	"github.com/czcorpus/hltscl"
+)
+
+// LabelsColumnName is the name of the column containing instance
+// labels (as a JSON object) in tables of the wide layout
+const LabelsColumnName = "labels"
+
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidateLabels tests whether the label names can be used as tag
+// names in all the outputs and whether they do not collide with
+// columns of the registered records
+func ValidateLabels(labels map[string]string) error {
+	reserved := map[string]bool{TimeColumnName: true, LabelsColumnName: true}
+	for table := range tableRecords {
+		for _, col := range ExpectedColumns(table) {
+			reserved[col] = true
+		}
+	}
+	for name := range labels {
+		if !labelNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid label name %s (only letters, digits and underscores are allowed)", name)
+		}
+		if reserved[name] {
+			return fmt.Errorf("label name %s collides with a column of a record", name)
+		}
+	}
+	return nil
+}
+
+// LabeledRecord attaches static labels (e.g. environment or role
+// of the monitored instance) to a record. The labels are added to
+// the record's tags, in TimescaleDB (wide layout) they are stored
+// as a JSON object in the `labels` column.
+type LabeledRecord struct {
+	Timescalable
+	Labels map[string]string
+}
+
+func (r *LabeledRecord) labelsJSON() string {
+	data, _ := json.Marshal(r.Labels)
+	return string(data)
+}
+
+// ToInfluxDB provides tags of the record extended with the labels
+// (record's own tags take precedence) and fields of the record
+func (r *LabeledRecord) ToInfluxDB() (map[string]string, map[string]any) {
+	tags, fields := r.Timescalable.ToInfluxDB()
+	ans := maps.Clone(r.Labels)
+	maps.Copy(ans, tags)
+	return ans, fields
+}
+
+func (r *LabeledRecord) ToTimescaleDB(tableWriter *hltscl.TableWriter) *hltscl.Entry {
+	return r.Timescalable.ToTimescaleDB(tableWriter).Str(LabelsColumnName, r.labelsJSON())
+}
+
+// CounterFields provides counter fields of the record (if it is
+// a CounterRecord)
+func (r *LabeledRecord) CounterFields() []string {
+	if cr, ok := r.Timescalable.(CounterRecord); ok {
+		return cr.CounterFields()
+	}
+	return nil
+}
+
+// CumulativeValues provides cumulative values of the record (if it
+// is a CumulativeRecord)
+func (r *LabeledRecord) CumulativeValues() (time.Time, map[string]any, bool) {
+	if cr, ok := r.Timescalable.(CumulativeRecord); ok {
+		return cr.CumulativeValues()
+	}
+	return time.Time{}, nil, false
+}
+
+// MarshalJSON provides JSON of the record with the labels
+// added as the `labels` object
+func (r *LabeledRecord) MarshalJSON() ([]byte, error) {
+	data, err := r.Timescalable.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var ans map[string]json.RawMessage
+	if err := json.Unmarshal(data, &ans); err != nil {
+		return nil, err
+	}
+	ans[LabelsColumnName] = json.RawMessage(r.labelsJSON())
+	return json.Marshal(ans)
+}
+
+// ----
+
+// labelingWriter attaches labels to all the records
+// before passing them to the wrapped writer
+type labelingWriter struct {
+	ReportingWriter
+	labels map[string]string
+}
+
+func (w *labelingWriter) Write(item Timescalable) {
+	w.ReportingWriter.Write(&LabeledRecord{Timescalable: item, Labels: w.labels})
+}
+
+func (w *labelingWriter) WriteBatch(items []Timescalable) {
+	labeled := make([]Timescalable, len(items))
+	for i, item := range items {
+		labeled[i] = &LabeledRecord{Timescalable: item, Labels: w.labels}
+	}
+	WriteAll(w.ReportingWriter, labeled)
+}
+
+// NewLabelingWriter creates a writer attaching the labels to all
+// the written records. In case there are no labels, the writer
+// is returned as it is.
+func NewLabelingWriter(writer ReportingWriter, labels map[string]string) ReportingWriter {
+	if len(labels) == 0 {
+		return writer
+	}
+	return &labelingWriter{ReportingWriter: writer, labels: labels}
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package reporting_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/czcorpus/mariadb-tscl/reporting/reportingtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelingWriter(t *testing.T) {
+	labels := map[string]string{"role": "primary", "application": "kontext"}
+	capture := reportingtest.NewWriter()
+	writer := reporting.NewLabelingWriter(capture, labels)
+	writer.AddTableWriter(reporting.MariaDBTSCLStatusMonitoringTable)
+	writer.Write(&reporting.ConnectionsStatus{
+		Created:  time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Instance: "db1",
+		Status:   db.Status{ComSelect: 12},
+	})
+
+	entries := capture.Entries()
+	require.Len(t, entries, 1)
+	assert.Equal(t, `{"application":"kontext","role":"primary"}`, entries[0].Values()[reporting.LabelsColumnName])
+
+	tags, fields := entries[0].Record.ToInfluxDB()
+	assert.Equal(t, map[string]string{"instance": "db1", "role": "primary", "application": "kontext"}, tags)
+	assert.Equal(t, 12, fields["com_select"])
+
+	cr, ok := entries[0].Record.(reporting.CounterRecord)
+	require.True(t, ok)
+	assert.Contains(t, cr.CounterFields(), "com_select")
+
+	data, err := entries[0].Record.MarshalJSON()
+	require.NoError(t, err)
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, "db1", decoded["instance"])
+	assert.Equal(t, map[string]any{"role": "primary", "application": "kontext"}, decoded["labels"])
+}
+
+func TestValidateLabels(t *testing.T) {
+	assert.NoError(t, reporting.ValidateLabels(map[string]string{"environment": "test", "dc_1": "prague"}))
+	assert.Error(t, reporting.ValidateLabels(map[string]string{"instance": "x"}))
+	assert.Error(t, reporting.ValidateLabels(map[string]string{"collector": "x"}))
+	assert.Error(t, reporting.ValidateLabels(map[string]string{"data-center": "x"}))
+}
+
+type batchCapture struct {
+	reporting.NullWriter
+	batches [][]reporting.Timescalable
+}
+
+func (w *batchCapture) WriteBatch(items []reporting.Timescalable) {
+	w.batches = append(w.batches, items)
+}
+
+func TestWriteAllKeepsBatches(t *testing.T) {
+	capture := &batchCapture{}
+	writer := reporting.NewLabelingWriter(
+		reporting.NewMultiWriter(capture, reportingtest.NewWriter()),
+		map[string]string{"role": "primary"},
+	)
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	reporting.WriteAll(writer, []reporting.Timescalable{
+		&reporting.ConnectionsStatus{Created: created, Instance: "db1"},
+		&reporting.ConnectionsStatus{Created: created, Instance: "db2"},
+	})
+	require.Len(t, capture.batches, 1)
+	require.Len(t, capture.batches[0], 2)
+	tags, _ := capture.batches[0][1].ToInfluxDB()
+	assert.Equal(t, map[string]string{"instance": "db2", "role": "primary"}, tags)
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package reporting
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(",", "\\,", " ", "\\ ")
+	tagEscaper         = strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ")
+	strFieldEscaper    = strings.NewReplacer("\"", "\\\"", "\\", "\\\\")
+)
+
+// FormatLineProtocol encodes a record in the InfluxDB line protocol.
+// Tags and fields are sorted by their names so the output is stable.
+func FormatLineProtocol(
+	measurement string,
+	t time.Time,
+	tags map[string]string,
+	fields map[string]any,
+) string {
+	var ans strings.Builder
+	ans.WriteString(measurementEscaper.Replace(measurement))
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		if tags[k] == "" {
+			continue // empty tag values are not allowed in the protocol
+		}
+		ans.WriteString(",")
+		ans.WriteString(tagEscaper.Replace(k))
+		ans.WriteString("=")
+		ans.WriteString(tagEscaper.Replace(tags[k]))
+	}
+	for i, k := range slices.Sorted(maps.Keys(fields)) {
+		if i == 0 {
+			ans.WriteString(" ")
+
+		} else {
+			ans.WriteString(",")
+		}
+		ans.WriteString(tagEscaper.Replace(k))
+		ans.WriteString("=")
+		switch tv := fields[k].(type) {
+		case int:
+			ans.WriteString(strconv.Itoa(tv) + "i")
+		case int64:
+			ans.WriteString(strconv.FormatInt(tv, 10) + "i")
+		case float64:
+			ans.WriteString(strconv.FormatFloat(tv, 'f', -1, 64))
+		case bool:
+			ans.WriteString(strconv.FormatBool(tv))
+		default:
+			ans.WriteString("\"" + strFieldEscaper.Replace(fmt.Sprintf("%v", tv)) + "\"")
+		}
+	}
+	ans.WriteString(" ")
+	ans.WriteString(strconv.FormatInt(t.UnixNano(), 10))
+	return ans.String()
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package reporting
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// MultiWriter passes all the records to multiple writers
+type MultiWriter struct {
+	writers []ReportingWriter
+}
+
+func (mw *MultiWriter) LogErrors() {
+	for _, w := range mw.writers {
+		w.LogErrors()
+	}
+}
+
+func (mw *MultiWriter) Write(item Timescalable) {
+	for _, w := range mw.writers {
+		w.Write(item)
+	}
+}
+
+// WriteBatch passes the records to all the writers
+// (see BatchWriter)
+func (mw *MultiWriter) WriteBatch(items []Timescalable) {
+	for _, w := range mw.writers {
+		WriteAll(w, items)
+	}
+}
+
+func (mw *MultiWriter) AddTableWriter(tableName string) {
+	for _, w := range mw.writers {
+		w.AddTableWriter(tableName)
+	}
+}
+
+func (mw *MultiWriter) Flush(ctx context.Context) error {
+	errs := make([]error, 0, len(mw.writers))
+	for _, w := range mw.writers {
+		errs = append(errs, w.Flush(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+func (mw *MultiWriter) QueueStats() []QueueStats {
+	ans := make([]QueueStats, 0, len(mw.writers))
+	for _, w := range mw.writers {
+		ans = append(ans, w.QueueStats()...)
+	}
+	return ans
+}
+
+// Close closes all the writers (even if some of them fail).
+// The writers are closed concurrently so a slow one does not
+// consume the time available for the others.
+func (mw *MultiWriter) Close(ctx context.Context) error {
+	errs := make([]error, len(mw.writers))
+	var wg sync.WaitGroup
+	for i, w := range mw.writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = w.Close(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// NewMultiWriter creates a writer passing records to all the provided
+// writers. In case there is just one writer, it is returned directly.
+func NewMultiWriter(writers ...ReportingWriter) ReportingWriter {
+	if len(writers) == 1 {
+		return writers[0]
+	}
+	return &MultiWriter{writers: writers}
+}
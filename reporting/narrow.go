@@ -0,0 +1,181 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package reporting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// LayoutWide stores each record as a single row with a column
+	// per field (see scripts/schema.sql)
+	LayoutWide = "wide"
+
+	// LayoutNarrow stores each field of a record as a separate
+	// (time, instance, metric, value) row so new metrics require
+	// no schema changes
+	LayoutNarrow = "narrow"
+
+	MariaDBTSCLMetricsTable    = "mariadb_tscl_metrics"
+	MariaDBTSCLMetricDictTable = "mariadb_tscl_metric_dict"
+
+	MetricKindCounter = "counter"
+	MetricKindGauge   = "gauge"
+)
+
+var (
+	narrowTableColumns = []string{TimeColumnName, "instance", "metric", "value", "labels"}
+	metricDictColumns  = []string{"metric", "source_table", "field", "kind"}
+)
+
+// MetricName provides a name of the metric representing
+// the field of the table in the narrow layout
+func MetricName(table, field string) string {
+	return table + "." + field
+}
+
+// narrowStatement creates a statement inserting a row for each numeric
+// field of the record. Tags other than the instance are stored
+// as a JSON object in the labels column. Names of the metrics
+// are returned along with the statement (if there are no numeric
+// fields, no metrics are returned and the statement must not be used).
+func narrowStatement(item Timescalable, tz *time.Location) (statement, []string) {
+	tags, fields := item.ToInfluxDB()
+	labels := maps.Clone(tags)
+	delete(labels, "instance")
+	var labelsJSON string
+	if len(labels) > 0 {
+		data, _ := json.Marshal(labels)
+		labelsJSON = string(data)
+	}
+	var sql strings.Builder
+	fmt.Fprintf(&sql, "INSERT INTO %s (%s) VALUES ", MariaDBTSCLMetricsTable, strings.Join(narrowTableColumns, ", "))
+	args := []any{item.GetTime().In(tz), tags["instance"], labelsJSON}
+	var metrics []string
+	for _, field := range slices.Sorted(maps.Keys(fields)) {
+		value, ok := NumericValue(fields[field])
+		if !ok {
+			continue
+		}
+		metric := MetricName(item.GetTableName(), field)
+		if len(metrics) > 0 {
+			sql.WriteString(", ")
+		}
+		fmt.Fprintf(&sql, "($1, $2, $%d, $%d, NULLIF($3::text, '')::jsonb)", len(args)+1, len(args)+2)
+		args = append(args, metric, value)
+		metrics = append(metrics, metric)
+	}
+	return statement{SQL: sql.String(), Args: args}, metrics
+}
+
+// metricDictStatement creates a statement registering the fields
+// of the record in the metric dictionary
+func metricDictStatement(item Timescalable, metrics []string) statement {
+	var counters []string
+	if cr, ok := item.(CounterRecord); ok {
+		counters = cr.CounterFields()
+	}
+	var sql strings.Builder
+	fmt.Fprintf(&sql, "INSERT INTO %s (%s) VALUES ", MariaDBTSCLMetricDictTable, strings.Join(metricDictColumns, ", "))
+	args := []any{item.GetTableName()}
+	for i, metric := range metrics {
+		field := strings.TrimPrefix(metric, item.GetTableName()+".")
+		kind := MetricKindGauge
+		if slices.Contains(counters, field) {
+			kind = MetricKindCounter
+		}
+		if i > 0 {
+			sql.WriteString(", ")
+		}
+		fmt.Fprintf(&sql, "($%d, $1, $%d, $%d)", len(args)+1, len(args)+2, len(args)+3)
+		args = append(args, metric, field, kind)
+	}
+	sql.WriteString(" ON CONFLICT (metric) DO UPDATE SET kind = excluded.kind")
+	return statement{SQL: sql.String(), Args: args}
+}
+
+// LoadNarrowStatusHistory is a variant of LoadStatusHistory
+// for the narrow layout
+func LoadNarrowStatusHistory(
+	ctx context.Context,
+	conn *pgxpool.Pool,
+	instance string,
+	since time.Time,
+) (map[string]int64, int, error) {
+	_, fields := (&ConnectionsStatus{}).ToInfluxDB()
+	metrics := make([]string, 0, len(fields))
+	for field := range fields {
+		metrics = append(metrics, MetricName(MariaDBTSCLStatusMonitoringTable, field))
+	}
+	rows, err := conn.Query(
+		ctx,
+		fmt.Sprintf(
+			"SELECT metric, COUNT(*), COALESCE(SUM(value), 0)::bigint, COALESCE(MAX(value), 0)::bigint "+
+				"FROM %s WHERE instance = $1 AND %s >= $2 AND metric = ANY($3) GROUP BY metric",
+			MariaDBTSCLMetricsTable, TimeColumnName,
+		),
+		instance, since, metrics,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load status history: %w", err)
+	}
+	defer rows.Close()
+	ans := make(map[string]int64, len(fields))
+	for field := range fields {
+		ans[field] = 0
+	}
+	var numRecords int
+	for rows.Next() {
+		var metric string
+		var count int
+		var sumValue, maxValue int64
+		if err := rows.Scan(&metric, &count, &sumValue, &maxValue); err != nil {
+			return nil, 0, fmt.Errorf("failed to load status history: %w", err)
+		}
+		field := strings.TrimPrefix(metric, MariaDBTSCLStatusMonitoringTable+".")
+		if statusGaugeColumns[field] {
+			ans[field] = maxValue
+
+		} else {
+			ans[field] = sumValue
+		}
+		numRecords = max(numRecords, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to load status history: %w", err)
+	}
+	return ans, numRecords, nil
+}
+
+// CheckNarrowTables tests whether the tables of the narrow layout
+// exist and contain all the required columns
+func CheckNarrowTables(ctx context.Context, conn *pgxpool.Pool) error {
+	if err := CheckTable(ctx, conn, MariaDBTSCLMetricsTable, narrowTableColumns); err != nil {
+		return err
+	}
+	return checkColumns(ctx, conn, MariaDBTSCLMetricDictTable, metricDictColumns)
+}
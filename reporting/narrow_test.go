@@ -0,0 +1,109 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package reporting
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNarrowStatement(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	stmt, metrics := narrowStatement(&ConnectionsStatus{
+		Created:    created,
+		Instance:   "db1",
+		IntervalMs: 10000,
+		Status:     db.Status{ComSelect: 12},
+	}, time.UTC)
+
+	_, fields := (&ConnectionsStatus{}).ToInfluxDB()
+	require.Len(t, metrics, len(fields))
+	assert.Len(t, stmt.Args, 3+2*len(fields))
+	assert.Equal(t, created, stmt.Args[0])
+	assert.Equal(t, "db1", stmt.Args[1])
+	assert.Equal(t, "", stmt.Args[2])
+	assert.Equal(t, len(fields), strings.Count(stmt.SQL, "NULLIF($3::text, '')::jsonb"))
+	for i, metric := range metrics {
+		if metric == MetricName(MariaDBTSCLStatusMonitoringTable, "com_select") {
+			assert.Equal(t, metric, stmt.Args[3+2*i])
+			assert.Equal(t, 12.0, stmt.Args[4+2*i])
+		}
+	}
+	// the statement must survive spilling to disk
+	_, err := json.Marshal(stmt)
+	assert.NoError(t, err)
+}
+
+func TestNarrowStatementLabels(t *testing.T) {
+	item := &CollectorSelfStatus{Instance: "db1", Collector: "global_status", CollectionTimeAvg: 1.5}
+	stmt, metrics := narrowStatement(item, time.UTC)
+	assert.Equal(t, `{"collector":"global_status"}`, stmt.Args[2])
+
+	dict := metricDictStatement(item, metrics)
+	args := make(map[string]string)
+	for i := 1; i+2 < len(dict.Args); i += 3 {
+		args[dict.Args[i].(string)] = dict.Args[i+2].(string)
+	}
+	assert.Equal(t, MariaDBTSCLSelfTable, dict.Args[0])
+	assert.Equal(t, MetricKindCounter, args[MetricName(MariaDBTSCLSelfTable, "collections")])
+	assert.Equal(t, MetricKindGauge, args[MetricName(MariaDBTSCLSelfTable, "collection_time_avg_ms")])
+	assert.Len(t, args, len(metrics))
+}
+
+func TestWriteNarrowRegistersMetricsOnceWritten(t *testing.T) {
+	queue, err := newTableQueue(MariaDBTSCLMetricsTable, QueueConf{Size: 10, Overflow: OverflowDropNewest})
+	require.NoError(t, err)
+	sw := &TimescaleDBWriter{
+		tz:           time.UTC,
+		layout:       LayoutNarrow,
+		tables:       map[string]*Table{MariaDBTSCLMetricsTable: {name: MariaDBTSCLMetricsTable, queue: queue}},
+		knownMetrics: make(map[string]bool),
+	}
+	item := &CollectorSelfStatus{Instance: "db1", Collector: "global_status", Collections: 1}
+	popAll := func() []statement {
+		var ans []statement
+		for queue.stats().Depth > 0 {
+			stmt, _ := queue.pop()
+			queue.done()
+			ans = append(ans, stmt)
+		}
+		return ans
+	}
+
+	// the registration is repeated until it is written
+	sw.Write(item)
+	sw.Write(item)
+	stmts := popAll()
+	require.Len(t, stmts, 4)
+	assert.Contains(t, stmts[0].SQL, MariaDBTSCLMetricDictTable)
+	assert.Contains(t, stmts[2].SQL, MariaDBTSCLMetricDictTable)
+
+	stmts[2].written()
+	sw.Write(item)
+	stmts = popAll()
+	require.Len(t, stmts, 1)
+	assert.Contains(t, stmts[0].SQL, MariaDBTSCLMetricsTable+" ")
+	assert.Nil(t, stmts[0].written)
+}
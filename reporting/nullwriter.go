@@ -18,7 +18,11 @@
 
 package reporting
 
-import "github.com/rs/zerolog/log"
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
 
 type NullWriter struct {
 }
@@ -42,3 +46,18 @@ func (sw *NullWriter) AddTableWriter(tableName string) {
 		Bool("fallbackReporting", true).
 		Msgf("NullWriter.AddTableWriter(%s)", tableName)
 }
+
+func (sw *NullWriter) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (sw *NullWriter) QueueStats() []QueueStats {
+	return []QueueStats{}
+}
+
+func (sw *NullWriter) Close(ctx context.Context) error {
+	log.Info().
+		Bool("fallbackReporting", true).
+		Msg("NullWriter.Close()")
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package otlp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/general"
+)
+
+const (
+	dfltEndpoint      = "http://localhost:4318/v1/metrics"
+	dfltBatchSize     = 1000
+	dfltFlushInterval = general.Duration(10 * time.Second)
+	dfltBufferSize    = 20000
+	dfltMaxRetries    = 3
+	dfltRetryDelay    = general.Duration(time.Second)
+	dfltTimeout       = general.Duration(10 * time.Second)
+)
+
+// Conf configures export of records as OpenTelemetry metrics
+// using OTLP/HTTP with the JSON encoding
+type Conf struct {
+
+	// Endpoint is a URL of the metrics endpoint of an OTLP receiver
+	// (e.g. http://otel-collector:4318/v1/metrics)
+	Endpoint string `json:"endpoint"`
+
+	// Headers are added to each request (e.g. for authentication)
+	Headers map[string]string `json:"headers"`
+
+	// ResourceAttributes are added to the attributes of the resource
+	// representing the monitored instance (service.name,
+	// service.instance.id and db.system are set automatically
+	// but they can be overridden here)
+	ResourceAttributes map[string]string `json:"resourceAttributes"`
+
+	// BatchSize is a maximum number of data points sent at once
+	BatchSize int `json:"batchSize"`
+
+	// FlushInterval specifies how often pending data points are sent
+	// (a full batch is sent immediately)
+	FlushInterval general.Duration `json:"flushInterval"`
+
+	// BufferSize is a maximum number of pending data points. In case
+	// the receiver is unavailable for a long time, the oldest data
+	// points are dropped.
+	BufferSize int `json:"bufferSize"`
+
+	// MaxRetries is a number of repeated attempts to send a batch
+	// before it is postponed to the next flush
+	MaxRetries int              `json:"maxRetries"`
+	RetryDelay general.Duration `json:"retryDelay"`
+
+	// Timeout limits a single HTTP request
+	Timeout general.Duration `json:"timeout"`
+}
+
+func (conf *Conf) ValidateAndDefaults() error {
+	if conf == nil {
+		return nil
+	}
+	if conf.Endpoint == "" {
+		conf.Endpoint = dfltEndpoint
+	}
+	u, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid sinks.otlp.endpoint: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("sinks.otlp.endpoint must be an HTTP(S) URL")
+	}
+	if conf.BatchSize < 0 || conf.BufferSize < 0 || conf.MaxRetries < 0 {
+		return errors.New("sinks.otlp.batchSize, bufferSize and maxRetries must not be negative")
+	}
+	if conf.FlushInterval < 0 || conf.RetryDelay < 0 || conf.Timeout < 0 {
+		return errors.New("sinks.otlp.flushInterval, retryDelay and timeout must not be negative")
+	}
+	if conf.BatchSize == 0 {
+		conf.BatchSize = dfltBatchSize
+	}
+	if conf.FlushInterval == 0 {
+		conf.FlushInterval = dfltFlushInterval
+	}
+	if conf.BufferSize == 0 {
+		conf.BufferSize = dfltBufferSize
+	}
+	if conf.BufferSize < conf.BatchSize {
+		return fmt.Errorf("sinks.otlp.bufferSize must not be smaller than batchSize (%d)", conf.BatchSize)
+	}
+	if conf.MaxRetries == 0 {
+		conf.MaxRetries = dfltMaxRetries
+	}
+	if conf.RetryDelay == 0 {
+		conf.RetryDelay = dfltRetryDelay
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = dfltTimeout
+	}
+	return nil
+}
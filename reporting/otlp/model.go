@@ -0,0 +1,163 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package otlp
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The types below follow the JSON encoding of the OTLP
+// ExportMetricsServiceRequest (64-bit integers are encoded
+// as strings, enums as numbers).
+
+// aggregationTemporalityCumulative is the value
+// of AGGREGATION_TEMPORALITY_CUMULATIVE
+const aggregationTemporalityCumulative = 2
+
+// scopeName identifies the instrumentation scope of all the metrics
+const scopeName = "github.com/czcorpus/mariadb-tscl"
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type dataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	AsInt             string     `json:"asInt,omitempty"`
+	AsDouble          *float64   `json:"asDouble,omitempty"`
+}
+
+type sum struct {
+	DataPoints             []dataPoint `json:"dataPoints"`
+	AggregationTemporality int         `json:"aggregationTemporality"`
+	IsMonotonic            bool        `json:"isMonotonic"`
+}
+
+type gauge struct {
+	DataPoints []dataPoint `json:"dataPoints"`
+}
+
+type metric struct {
+	Name  string `json:"name"`
+	Sum   *sum   `json:"sum,omitempty"`
+	Gauge *gauge `json:"gauge,omitempty"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type exportRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type exportResponse struct {
+	PartialSuccess *struct {
+		RejectedDataPoints any    `json:"rejectedDataPoints"`
+		ErrorMessage       string `json:"errorMessage"`
+	} `json:"partialSuccess"`
+}
+
+// ----
+
+// point is a single data point along with
+// the information needed to place it in a request
+type point struct {
+	instance string
+	metric   string
+	sum      bool
+	dp       dataPoint
+}
+
+func formatTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func attributes(attrs map[string]string) []keyValue {
+	ans := make([]keyValue, 0, len(attrs))
+	for _, k := range slices.Sorted(maps.Keys(attrs)) {
+		ans = append(ans, keyValue{Key: k, Value: anyValue{StringValue: attrs[k]}})
+	}
+	return ans
+}
+
+// newExportRequest groups data points by instances (resources)
+// and metrics. The resourceAttrs function provides attributes
+// of an instance resource.
+func newExportRequest(points []point, resourceAttrs func(instance string) []keyValue) *exportRequest {
+	points = slices.Clone(points)
+	slices.SortStableFunc(points, func(a, b point) int {
+		return cmp.Or(strings.Compare(a.instance, b.instance), strings.Compare(a.metric, b.metric))
+	})
+	var ans exportRequest
+	var metrics *[]metric
+	for i, p := range points {
+		if i == 0 || p.instance != points[i-1].instance {
+			ans.ResourceMetrics = append(ans.ResourceMetrics, resourceMetrics{
+				Resource:     resource{Attributes: resourceAttrs(p.instance)},
+				ScopeMetrics: []scopeMetrics{{Scope: scope{Name: scopeName}}},
+			})
+			metrics = &ans.ResourceMetrics[len(ans.ResourceMetrics)-1].ScopeMetrics[0].Metrics
+		}
+		if len(*metrics) == 0 || (*metrics)[len(*metrics)-1].Name != p.metric {
+			m := metric{Name: p.metric}
+			if p.sum {
+				m.Sum = &sum{AggregationTemporality: aggregationTemporalityCumulative, IsMonotonic: true}
+
+			} else {
+				m.Gauge = &gauge{}
+			}
+			*metrics = append(*metrics, m)
+		}
+		m := &(*metrics)[len(*metrics)-1]
+		if m.Sum != nil {
+			m.Sum.DataPoints = append(m.Sum.DataPoints, p.dp)
+
+		} else {
+			m.Gauge.DataPoints = append(m.Gauge.DataPoints, p.dp)
+		}
+	}
+	return &ans
+}
@@ -0,0 +1,226 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package otlp provides a reporting writer exporting records
+// as OpenTelemetry metrics via OTLP/HTTP (JSON encoding).
+// Counter fields of records are exported as cumulative monotonic
+// sums, other numeric fields as gauges. Each instance is
+// represented by a separate resource.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/rs/zerolog/log"
+)
+
+const serviceName = "mariadb-tscl"
+
+// accumulated is a cumulative value of a counter
+// calculated from differences
+type accumulated struct {
+	start time.Time
+	value float64
+}
+
+// Writer converts records to data points and sends them in batches
+// (see reporting.BatchQueue)
+type Writer struct {
+	reporting.BaseWriter
+	conf   *Conf
+	client *http.Client
+	queue  *reporting.BatchQueue[point]
+
+	// totals contains cumulative values of counters of records
+	// which are not able to provide them (see reporting.CumulativeRecord).
+	// Such values are accumulated since the first record.
+	totals   map[string]*accumulated
+	totalsMu sync.Mutex
+}
+
+func (w *Writer) Write(item reporting.Timescalable) {
+	w.queue.Push(w.encode(item)...)
+}
+
+// encode creates a data point for each numeric field of the record
+func (w *Writer) encode(item reporting.Timescalable) []point {
+	w.totalsMu.Lock()
+	defer w.totalsMu.Unlock()
+	tags, fields := item.ToInfluxDB()
+	instance := tags["instance"]
+	attrs := maps.Clone(tags)
+	delete(attrs, "instance")
+	var counters []string
+	if cr, ok := item.(reporting.CounterRecord); ok {
+		counters = cr.CounterFields()
+	}
+	var start time.Time
+	var cumulative map[string]any
+	var hasCumulative bool
+	if cr, ok := item.(reporting.CumulativeRecord); ok {
+		start, cumulative, hasCumulative = cr.CumulativeValues()
+	}
+	now := formatTime(item.GetTime())
+	ans := make([]point, 0, len(fields))
+	for _, field := range slices.Sorted(maps.Keys(fields)) {
+		value, ok := reporting.NumericValue(fields[field])
+		if !ok {
+			continue
+		}
+		p := point{
+			instance: instance,
+			metric:   item.GetTableName() + "." + field,
+			sum:      slices.Contains(counters, field),
+			dp:       dataPoint{Attributes: attributes(attrs), TimeUnixNano: now},
+		}
+		if p.sum && hasCumulative {
+			value, _ = reporting.NumericValue(cumulative[field])
+			p.dp.StartTimeUnixNano = formatTime(start)
+
+		} else if p.sum {
+			key := instance + "\x00" + p.metric + "\x00" + fmt.Sprint(p.dp.Attributes)
+			acc, ok := w.totals[key]
+			if !ok {
+				acc = &accumulated{start: item.GetTime()}
+				w.totals[key] = acc
+			}
+			acc.value += value
+			value = acc.value
+			p.dp.StartTimeUnixNano = formatTime(acc.start)
+		}
+		if _, isFloat := fields[field].(float64); isFloat {
+			p.dp.AsDouble = &value
+
+		} else {
+			p.dp.AsInt = strconv.FormatInt(int64(value), 10)
+		}
+		ans = append(ans, p)
+	}
+	return ans
+}
+
+func (w *Writer) resourceAttrs(instance string) []keyValue {
+	attrs := map[string]string{
+		"service.name":        serviceName,
+		"service.instance.id": instance,
+		"db.system":           "mariadb",
+	}
+	maps.Copy(attrs, w.conf.ResourceAttributes)
+	return attributes(attrs)
+}
+
+// export sends a single request with the batch
+func (w *Writer) export(ctx context.Context, batch []point) error {
+	body, err := json.Marshal(newExportRequest(batch, w.resourceAttrs))
+	if err != nil {
+		return &reporting.PermanentError{Err: err}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.conf.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return &reporting.PermanentError{Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		var ans exportResponse
+		if json.Unmarshal(respBody, &ans) == nil && ans.PartialSuccess != nil &&
+			ans.PartialSuccess.ErrorMessage != "" {
+			log.Warn().
+				Any("rejected", ans.PartialSuccess.RejectedDataPoints).
+				Str("reason", ans.PartialSuccess.ErrorMessage).
+				Msg("OTLP receiver rejected some data points")
+		}
+		return nil
+	}
+	err = fmt.Errorf("OTLP receiver responded with %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return err
+	default:
+		return &reporting.PermanentError{Err: err}
+	}
+}
+
+// QueueStats provides state of the buffer of pending data points
+func (w *Writer) QueueStats() []reporting.QueueStats {
+	return []reporting.QueueStats{w.queue.Stats()}
+}
+
+// Flush requests export of all the pending data points and waits
+// until they are sent or until the context is done
+func (w *Writer) Flush(ctx context.Context) error {
+	return w.queue.Flush(ctx)
+}
+
+// Close stops accepting new records and exports the pending ones
+func (w *Writer) Close(ctx context.Context) error {
+	err := w.queue.Close(ctx)
+	w.client.CloseIdleConnections()
+	return err
+}
+
+// NewWriter creates a writer and starts its background loop.
+// The configuration is expected to be validated.
+func NewWriter(conf *Conf) *Writer {
+	ans := &Writer{
+		conf:   conf,
+		client: &http.Client{Timeout: conf.Timeout.Duration()},
+		totals: make(map[string]*accumulated),
+	}
+	ans.queue = reporting.NewBatchQueue(
+		reporting.BatchQueueConf[point]{
+			Name:          "otlp:" + conf.Endpoint,
+			BatchSize:     conf.BatchSize,
+			BufferSize:    conf.BufferSize,
+			FlushInterval: conf.FlushInterval.Duration(),
+			MaxRetries:    conf.MaxRetries,
+			RetryDelay:    conf.RetryDelay.Duration(),
+		},
+		ans.export,
+	)
+	return ans
+}
+
+// CheckEndpoint tests whether the receiver is reachable
+func CheckEndpoint(ctx context.Context, conf *Conf) error {
+	if err := reporting.CheckURLReachable(ctx, conf.Endpoint); err != nil {
+		return fmt.Errorf("OTLP receiver unreachable: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/general"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver is a stand-in OTLP receiver responding with the specified
+// status codes (and with 200 once they are used up)
+type receiver struct {
+	mu        sync.Mutex
+	responses []int
+	requests  []exportRequest
+	headers   []http.Header
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.headers = append(rc.headers, r.Header.Clone())
+	if len(rc.responses) > 0 {
+		code := rc.responses[0]
+		rc.responses = rc.responses[1:]
+		if code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+	}
+	var req exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rc.requests = append(rc.requests, req)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
+// findMetric returns the metric along with its resource attributes
+func findMetric(reqs []exportRequest, name string) (*metric, []keyValue) {
+	for _, req := range reqs {
+		for _, rm := range req.ResourceMetrics {
+			for _, sm := range rm.ScopeMetrics {
+				for i, m := range sm.Metrics {
+					if m.Name == name {
+						return &sm.Metrics[i], rm.Resource.Attributes
+					}
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+func newTestWriter(t *testing.T, rc *receiver) *Writer {
+	srv := httptest.NewServer(rc)
+	t.Cleanup(srv.Close)
+	conf := &Conf{
+		Endpoint:           srv.URL + "/v1/metrics",
+		Headers:            map[string]string{"Authorization": "Bearer secret"},
+		ResourceAttributes: map[string]string{"deployment.environment": "test"},
+		FlushInterval:      general.Duration(time.Hour),
+		RetryDelay:         general.Duration(time.Millisecond),
+	}
+	require.NoError(t, conf.ValidateAndDefaults())
+	return NewWriter(conf)
+}
+
+func TestExportStatus(t *testing.T) {
+	rc := &receiver{responses: []int{http.StatusServiceUnavailable}}
+	w := newTestWriter(t, rc)
+	created := time.Unix(1700000000, 0)
+	w.Write(&reporting.ConnectionsStatus{
+		Created:     created,
+		Instance:    "db1",
+		IntervalMs:  10000,
+		Status:      db.Status{ComSelect: 12, ThreadsConnected: 4, Uptime: 3600},
+		Totals:      &db.Status{ComSelect: 112, ThreadsConnected: 4, Uptime: 3600},
+		TotalsStart: created.Add(-time.Hour),
+	})
+	require.NoError(t, w.Close(context.Background()))
+
+	require.Len(t, rc.requests, 1)
+	assert.Equal(t, "Bearer secret", rc.headers[1].Get("Authorization"))
+	assert.Equal(t, "application/json", rc.headers[1].Get("Content-Type"))
+
+	m, res := findMetric(rc.requests, "mariadb_tscl_status_monitoring.com_select")
+	require.NotNil(t, m)
+	require.NotNil(t, m.Sum)
+	assert.Equal(t, aggregationTemporalityCumulative, m.Sum.AggregationTemporality)
+	assert.True(t, m.Sum.IsMonotonic)
+	require.Len(t, m.Sum.DataPoints, 1)
+	assert.Equal(t, "112", m.Sum.DataPoints[0].AsInt)
+	assert.Equal(t, formatTime(created.Add(-time.Hour)), m.Sum.DataPoints[0].StartTimeUnixNano)
+	assert.Equal(t, formatTime(created), m.Sum.DataPoints[0].TimeUnixNano)
+	assert.Contains(t, res, keyValue{Key: "service.instance.id", Value: anyValue{StringValue: "db1"}})
+	assert.Contains(t, res, keyValue{Key: "deployment.environment", Value: anyValue{StringValue: "test"}})
+
+	m, _ = findMetric(rc.requests, "mariadb_tscl_status_monitoring.threads_connected")
+	require.NotNil(t, m)
+	require.NotNil(t, m.Gauge)
+	assert.Equal(t, "4", m.Gauge.DataPoints[0].AsInt)
+	assert.Empty(t, m.Gauge.DataPoints[0].StartTimeUnixNano)
+}
+
+func TestExportAccumulatesDifferences(t *testing.T) {
+	rc := &receiver{}
+	w := newTestWriter(t, rc)
+	first := time.Unix(1700000000, 0)
+	w.Write(&reporting.CollectorSelfStatus{
+		Created: first, Instance: "db1", Collector: "global_status", Collections: 6, CollectionTimeAvg: 1.5})
+	w.Write(&reporting.CollectorSelfStatus{
+		Created: first.Add(time.Minute), Instance: "db1", Collector: "global_status", Collections: 5})
+	require.NoError(t, w.Close(context.Background()))
+
+	m, _ := findMetric(rc.requests, "mariadb_tscl_self.collections")
+	require.NotNil(t, m)
+	require.NotNil(t, m.Sum)
+	require.Len(t, m.Sum.DataPoints, 2)
+	assert.Equal(t, "6", m.Sum.DataPoints[0].AsInt)
+	assert.Equal(t, "11", m.Sum.DataPoints[1].AsInt)
+	assert.Equal(t, formatTime(first), m.Sum.DataPoints[1].StartTimeUnixNano)
+	assert.Equal(
+		t,
+		[]keyValue{{Key: "collector", Value: anyValue{StringValue: "global_status"}}},
+		m.Sum.DataPoints[1].Attributes,
+	)
+
+	m, _ = findMetric(rc.requests, "mariadb_tscl_self.collection_time_avg_ms")
+	require.NotNil(t, m)
+	require.NotNil(t, m.Gauge)
+	require.NotNil(t, m.Gauge.DataPoints[0].AsDouble)
+	assert.Equal(t, 1.5, *m.Gauge.DataPoints[0].AsDouble)
+}
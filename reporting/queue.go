@@ -0,0 +1,594 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package reporting
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// OverflowPolicy specifies what happens with a new record
+// in case a table queue is full
+type OverflowPolicy string
+
+const (
+	// OverflowBlock makes the writer wait until there is a free slot
+	OverflowBlock OverflowPolicy = "block"
+
+	// OverflowDropOldest removes the oldest queued record
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+
+	// OverflowDropNewest discards the new record
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+
+	// OverflowSpill stores records to a file and writes them later
+	OverflowSpill OverflowPolicy = "spill"
+
+	queueWarnRatio    = 0.8
+	queueRearmRatio   = 0.5
+	spillFileSuffix   = ".spill.jsonl"
+	spillOffsetSuffix = ".offset"
+	spilledTimeLayout = time.RFC3339Nano
+)
+
+func (p OverflowPolicy) Validate() error {
+	switch p {
+	case OverflowBlock, OverflowDropOldest, OverflowDropNewest, OverflowSpill:
+		return nil
+	default:
+		return fmt.Errorf(
+			"invalid overflow policy %s (expected one of %s, %s, %s, %s)",
+			p, OverflowBlock, OverflowDropOldest, OverflowDropNewest, OverflowSpill,
+		)
+	}
+}
+
+// QueueStats describes actual state of a table queue
+type QueueStats struct {
+	Table    string `json:"table"`
+	Depth    int    `json:"depth"`
+	Capacity int    `json:"capacity"`
+	Spilled  int    `json:"spilled"`
+	Dropped  int64  `json:"dropped"`
+
+	// WriteErrors is a number of entries the database refused
+	// (filled in by the writer, not by the queue itself)
+	WriteErrors int64 `json:"writeErrors"`
+}
+
+// ----
+
+// statement is an SQL command ready to be executed
+type statement struct {
+	SQL  string
+	Args []any
+
+	// written is called (if set) once the statement is written.
+	// It is not kept in the spill file.
+	written func()
+}
+
+type spilledArg struct {
+	Type  string          `json:"t"`
+	Value json.RawMessage `json:"v"`
+}
+
+type spilledStatement struct {
+	SQL  string       `json:"sql"`
+	Args []spilledArg `json:"args"`
+}
+
+// MarshalJSON encodes the statement including types of the arguments
+// so they can be restored exactly (e.g. ints would become floats
+// otherwise)
+func (stmt statement) MarshalJSON() ([]byte, error) {
+	ans := spilledStatement{SQL: stmt.SQL, Args: make([]spilledArg, len(stmt.Args))}
+	for i, arg := range stmt.Args {
+		var tp string
+		var v any
+		switch tv := arg.(type) {
+		case time.Time:
+			tp, v = "time", tv.Format(spilledTimeLayout)
+		case int:
+			tp, v = "int", tv
+		case int64:
+			tp, v = "int", tv
+		case float64:
+			tp, v = "float", tv
+		case bool:
+			tp, v = "bool", tv
+		case string:
+			tp, v = "str", tv
+		default:
+			return nil, fmt.Errorf("unsupported statement argument type %T", arg)
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		ans.Args[i] = spilledArg{Type: tp, Value: raw}
+	}
+	return json.Marshal(ans)
+}
+
+func (stmt *statement) UnmarshalJSON(data []byte) error {
+	var src spilledStatement
+	if err := json.Unmarshal(data, &src); err != nil {
+		return err
+	}
+	stmt.SQL = src.SQL
+	stmt.Args = make([]any, len(src.Args))
+	for i, arg := range src.Args {
+		var err error
+		switch arg.Type {
+		case "time":
+			var v string
+			if err = json.Unmarshal(arg.Value, &v); err == nil {
+				stmt.Args[i], err = time.Parse(spilledTimeLayout, v)
+			}
+		case "int":
+			var v int
+			err = json.Unmarshal(arg.Value, &v)
+			stmt.Args[i] = v
+		case "float":
+			var v float64
+			err = json.Unmarshal(arg.Value, &v)
+			stmt.Args[i] = v
+		case "bool":
+			var v bool
+			err = json.Unmarshal(arg.Value, &v)
+			stmt.Args[i] = v
+		case "str":
+			var v string
+			err = json.Unmarshal(arg.Value, &v)
+			stmt.Args[i] = v
+		default:
+			err = fmt.Errorf("unknown argument type %s", arg.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode spilled statement: %w", err)
+		}
+	}
+	return nil
+}
+
+// ----
+
+// spillFile is a file-based FIFO of statements. Consumed statements
+// are not removed from the file, instead, the offset of the first
+// unconsumed one is stored along with the file. Unconsumed statements
+// (e.g. left there by a previous run) are read again once the file
+// is opened. The file is emptied once all the statements are consumed.
+type spillFile struct {
+	path   string
+	w      *os.File
+	r      *os.File
+	reader *bufio.Reader
+
+	// offset is a position of the first unconsumed statement
+	offset int64
+
+	// readLen is a length of the line returned by the last call
+	// to next (zero if there is no such unconsumed line)
+	readLen int64
+
+	count int
+}
+
+func (sf *spillFile) offsetPath() string {
+	return sf.path + spillOffsetSuffix
+}
+
+func (sf *spillFile) append(stmt statement) error {
+	data, err := json.Marshal(stmt)
+	if err != nil {
+		return err
+	}
+	if _, err := sf.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write to spill file %s: %w", sf.path, err)
+	}
+	sf.count++
+	return nil
+}
+
+// next reads the first unconsumed statement. The statement stays
+// in the file until consume is called (i.e. calling next again
+// provides the same statement).
+func (sf *spillFile) next() (statement, error) {
+	var stmt statement
+	if _, err := sf.r.Seek(sf.offset, io.SeekStart); err != nil {
+		return stmt, fmt.Errorf("failed to read spill file %s: %w", sf.path, err)
+	}
+	sf.reader.Reset(sf.r)
+	line, err := sf.reader.ReadBytes('\n')
+	if err != nil {
+		sf.reset()
+		if errors.Is(err, io.EOF) {
+			err = fmt.Errorf("unexpected end of spill file %s", sf.path)
+		}
+		return stmt, err
+	}
+	sf.readLen = int64(len(line))
+	if err := json.Unmarshal(line, &stmt); err != nil {
+		sf.consume()
+		return stmt, err
+	}
+	return stmt, nil
+}
+
+// consume removes the statement provided by the last call to next
+// and stores the new offset so the statement is not read again
+// after a restart.
+func (sf *spillFile) consume() {
+	if sf.readLen == 0 {
+		return
+	}
+	sf.offset += sf.readLen
+	sf.readLen = 0
+	sf.count--
+	if sf.count == 0 {
+		sf.reset()
+		return
+	}
+	if err := writeFileAtomic(sf.offsetPath(), strconv.AppendInt(nil, sf.offset, 10)); err != nil {
+		log.Error().Err(err).Str("path", sf.path).Msg("failed to store spill file offset")
+	}
+}
+
+// prepend stores the statements before all the unconsumed ones.
+// The file is rewritten so it contains no consumed statements
+// and it must be closed afterwards.
+func (sf *spillFile) prepend(stmts []statement) error {
+	tmpPath := sf.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite spill file %s: %w", sf.path, err)
+	}
+	defer tmp.Close()
+	bw := bufio.NewWriter(tmp)
+	for _, stmt := range stmts {
+		data, err := json.Marshal(stmt)
+		if err != nil {
+			return err
+		}
+		bw.Write(append(data, '\n'))
+	}
+	if _, err := sf.r.Seek(sf.offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewrite spill file %s: %w", sf.path, err)
+	}
+	if _, err := io.Copy(bw, sf.r); err != nil {
+		return fmt.Errorf("failed to rewrite spill file %s: %w", sf.path, err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to rewrite spill file %s: %w", sf.path, err)
+	}
+	if err := os.Rename(tmpPath, sf.path); err != nil {
+		return fmt.Errorf("failed to rewrite spill file %s: %w", sf.path, err)
+	}
+	sf.offset = 0
+	sf.readLen = 0
+	sf.count += len(stmts)
+	if err := os.Remove(sf.offsetPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rewrite spill file %s: %w", sf.path, err)
+	}
+	return nil
+}
+
+// reset empties the file
+func (sf *spillFile) reset() {
+	sf.count = 0
+	sf.offset = 0
+	sf.readLen = 0
+	if err := sf.w.Truncate(0); err != nil {
+		log.Error().Err(err).Str("path", sf.path).Msg("failed to truncate spill file")
+	}
+	if err := os.Remove(sf.offsetPath()); err != nil && !os.IsNotExist(err) {
+		log.Error().Err(err).Str("path", sf.path).Msg("failed to remove spill file offset")
+	}
+}
+
+func (sf *spillFile) close() {
+	sf.w.Close()
+	sf.r.Close()
+}
+
+// readSpillOffset reads a stored offset of the first unconsumed
+// statement. Missing or invalid values are reported as zero.
+func readSpillOffset(sf *spillFile) int64 {
+	data, err := os.ReadFile(sf.offsetPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn().Err(err).Str("path", sf.path).Msg("failed to read spill file offset, reading from the start")
+		}
+		return 0
+	}
+	offset, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil || offset < 0 {
+		log.Warn().Str("path", sf.path).Msg("invalid spill file offset, reading from the start")
+		return 0
+	}
+	// the offset must point to the start of a line
+	if offset > 0 {
+		buf := make([]byte, 1)
+		if _, err := sf.r.ReadAt(buf, offset-1); err != nil || buf[0] != '\n' {
+			log.Warn().Str("path", sf.path).Msg("invalid spill file offset, reading from the start")
+			return 0
+		}
+	}
+	return offset
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func openSpillFile(path string) (*spillFile, error) {
+	w, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spill file: %w", err)
+	}
+	r, err := os.Open(path)
+	if err != nil {
+		w.Close()
+		return nil, fmt.Errorf("failed to open spill file: %w", err)
+	}
+	ans := &spillFile{path: path, w: w, r: r}
+	ans.offset = readSpillOffset(ans)
+	if _, err := r.Seek(ans.offset, io.SeekStart); err != nil {
+		ans.close()
+		return nil, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		ans.count++
+	}
+	if err := scanner.Err(); err != nil {
+		ans.close()
+		return nil, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	ans.reader = bufio.NewReader(r)
+	if ans.count > 0 {
+		log.Info().
+			Str("path", path).
+			Int("records", ans.count).
+			Msg("found records spilled by a previous run, they will be written")
+
+	} else if ans.offset > 0 {
+		ans.reset()
+	}
+	return ans, nil
+}
+
+// ----
+
+// tableQueue is a bounded FIFO of statements waiting to be
+// written to a table. Its behavior in case it is full is
+// controlled by an OverflowPolicy.
+type tableQueue struct {
+	table    string
+	mu       sync.Mutex
+	cond     *sync.Cond
+	items    []statement
+	capacity int
+	policy   OverflowPolicy
+	spill    *spillFile
+	closed   bool
+	inFlight bool
+
+	// inFlightSpilled is set if the statement being written
+	// has been read from the spill file
+	inFlightSpilled bool
+
+	dropped int64
+	warned  bool
+}
+
+func (q *tableQueue) depth() int {
+	if q.spill != nil {
+		return len(q.items) + q.spill.count
+	}
+	return len(q.items)
+}
+
+// checkFill logs a warning once the queue starts to fill up
+// (the warning is re-armed after the queue gets emptier)
+func (q *tableQueue) checkFill() {
+	ratio := float64(len(q.items)) / float64(q.capacity)
+	if !q.warned && ratio >= queueWarnRatio {
+		q.warned = true
+		log.Warn().
+			Str("table", q.table).
+			Int("depth", q.depth()).
+			Int("capacity", q.capacity).
+			Str("overflowPolicy", string(q.policy)).
+			Msg("reporting queue is filling up")
+
+	} else if q.warned && ratio < queueRearmRatio {
+		q.warned = false
+		log.Info().Str("table", q.table).Int("depth", q.depth()).Msg("reporting queue recovered")
+	}
+}
+
+func (q *tableQueue) push(stmt statement) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.policy == OverflowBlock && len(q.items) >= q.capacity && !q.closed {
+		q.cond.Wait()
+	}
+	switch {
+	case q.closed:
+		q.dropped++
+	case q.spill != nil && (q.spill.count > 0 || len(q.items) >= q.capacity):
+		if err := q.spill.append(stmt); err != nil {
+			log.Error().Err(err).Str("table", q.table).Msg("failed to spill record, dropping")
+			q.dropped++
+		}
+	case len(q.items) < q.capacity:
+		q.items = append(q.items, stmt)
+	case q.policy == OverflowDropOldest:
+		q.items = append(q.items[1:], stmt)
+		q.dropped++
+	default: // OverflowDropNewest
+		q.dropped++
+	}
+	q.checkFill()
+	q.cond.Broadcast()
+}
+
+// pop blocks until there is a statement available. The second
+// returned value is false once the queue is closed and empty.
+// Each successful pop must be followed by a call to done.
+func (q *tableQueue) pop() (statement, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if len(q.items) > 0 {
+			stmt := q.items[0]
+			q.items = q.items[1:]
+			q.inFlight = true
+			q.checkFill()
+			q.cond.Broadcast()
+			return stmt, true
+		}
+		if q.spill != nil && q.spill.count > 0 {
+			stmt, err := q.spill.next()
+			if err != nil {
+				log.Error().Err(err).Str("table", q.table).Msg("failed to read spilled record")
+				continue
+			}
+			q.inFlight = true
+			q.inFlightSpilled = true
+			return stmt, true
+		}
+		if q.closed {
+			return statement{}, false
+		}
+		q.cond.Wait()
+	}
+}
+
+// requeue returns a statement which could not be written
+// to the front of the queue so it is written first once the
+// database is available again. It replaces the call to done.
+func (q *tableQueue) requeue(stmt statement) {
+	q.mu.Lock()
+	if !q.inFlightSpilled {
+		// a spilled statement stays in the file until consumed
+		q.items = append([]statement{stmt}, q.items...)
+	}
+	q.inFlight = false
+	q.inFlightSpilled = false
+	q.checkFill()
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+func (q *tableQueue) done() {
+	q.mu.Lock()
+	if q.inFlightSpilled {
+		q.spill.consume()
+	}
+	q.inFlight = false
+	q.inFlightSpilled = false
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// idle tests whether there is nothing queued or being written
+func (q *tableQueue) idle() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.depth() == 0 && !q.inFlight
+}
+
+func (q *tableQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// release closes the spill file (if any); the queue
+// must not be used afterwards. Statements which have not
+// been written are stored to the spill file so they can
+// be written after a restart.
+func (q *tableQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.spill == nil {
+		return
+	}
+	// statements in memory are older than the spilled ones
+	if len(q.items) > 0 {
+		if err := q.spill.prepend(q.items); err != nil {
+			log.Error().Err(err).Str("table", q.table).Msg("failed to spill records, dropping")
+			q.dropped += int64(len(q.items))
+		}
+	}
+	q.items = q.items[:0]
+	q.spill.close()
+}
+
+func (q *tableQueue) stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ans := QueueStats{
+		Table:    q.table,
+		Depth:    q.depth(),
+		Capacity: q.capacity,
+		Dropped:  q.dropped,
+	}
+	if q.spill != nil {
+		ans.Spilled = q.spill.count
+	}
+	return ans
+}
+
+func newTableQueue(table string, conf QueueConf) (*tableQueue, error) {
+	ans := &tableQueue{
+		table:    table,
+		items:    make([]statement, 0, conf.Size),
+		capacity: conf.Size,
+		policy:   conf.Overflow,
+	}
+	ans.cond = sync.NewCond(&ans.mu)
+	if conf.Overflow == OverflowSpill {
+		spill, err := openSpillFile(filepath.Join(conf.SpillDir, table+spillFileSuffix))
+		if err != nil {
+			return nil, err
+		}
+		ans.spill = spill
+	}
+	return ans, nil
+}
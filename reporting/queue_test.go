@@ -0,0 +1,103 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package reporting
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueRequeueKeepsOrder(t *testing.T) {
+	queue, err := newTableQueue("test", QueueConf{Size: 10, Overflow: OverflowDropNewest})
+	require.NoError(t, err)
+	queue.push(statement{SQL: "a"})
+	queue.push(statement{SQL: "b"})
+	stmt, ok := queue.pop()
+	require.True(t, ok)
+	assert.False(t, queue.idle())
+	queue.requeue(stmt)
+	stmt, _ = queue.pop()
+	assert.Equal(t, "a", stmt.SQL)
+	queue.done()
+	stmt, _ = queue.pop()
+	assert.Equal(t, "b", stmt.SQL)
+	queue.done()
+	assert.True(t, queue.idle())
+}
+
+func TestQueueReleaseKeepsUnwrittenInSpillFile(t *testing.T) {
+	conf := QueueConf{Size: 2, Overflow: OverflowSpill, SpillDir: t.TempDir()}
+	queue, err := newTableQueue("test", conf)
+	require.NoError(t, err)
+	for _, sql := range []string{"a", "b", "c"} {
+		queue.push(statement{SQL: sql, Args: []any{1}})
+	}
+	assert.Equal(t, 1, queue.stats().Spilled)
+	stmt, _ := queue.pop()
+	queue.requeue(stmt) // e.g. the database is unavailable
+	queue.close()
+	queue.release()
+
+	queue, err = newTableQueue("test", conf)
+	require.NoError(t, err)
+	defer queue.release()
+	assert.Equal(t, []string{"a", "b", "c"}, drainQueue(queue))
+}
+
+func drainQueue(queue *tableQueue) []string {
+	queue.close()
+	var ans []string
+	for {
+		stmt, ok := queue.pop()
+		if !ok {
+			return ans
+		}
+		ans = append(ans, stmt.SQL)
+		queue.done()
+	}
+}
+
+func TestQueueSpillFileReplaysOnlyUnconsumed(t *testing.T) {
+	conf := QueueConf{Size: 1, Overflow: OverflowSpill, SpillDir: t.TempDir()}
+	queue, err := newTableQueue("test", conf)
+	require.NoError(t, err)
+	for _, sql := range []string{"a", "b", "c", "d"} {
+		queue.push(statement{SQL: sql})
+	}
+	assert.Equal(t, 3, queue.stats().Spilled)
+	for _, expected := range []string{"a", "b"} {
+		stmt, _ := queue.pop()
+		assert.Equal(t, expected, stmt.SQL)
+		queue.done()
+	}
+	stmt, _ := queue.pop()
+	assert.Equal(t, "c", stmt.SQL)
+	queue.requeue(stmt) // a spilled statement stays in the file
+	assert.Equal(t, 2, queue.stats().Spilled)
+	queue.push(statement{SQL: "e"})
+	queue.close()
+	queue.release()
+
+	queue, err = newTableQueue("test", conf)
+	require.NoError(t, err)
+	defer queue.release()
+	assert.Equal(t, []string{"c", "d", "e"}, drainQueue(queue))
+}
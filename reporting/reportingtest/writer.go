@@ -0,0 +1,128 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package reportingtest provides a ReportingWriter which captures
+// written records in memory so they can be inspected by tests.
+package reportingtest
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/czcorpus/hltscl"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+)
+
+// Entry is a captured record along with the SQL statement
+// the TimescaleDB writer would use to store it
+type Entry struct {
+	Table  string
+	Record reporting.Timescalable
+	SQL    string
+	Args   []any
+}
+
+// Values provides column names and respective values
+// of the entry (including the time column)
+func (e Entry) Values() map[string]any {
+	ans := make(map[string]any)
+	start := strings.Index(e.SQL, "(")
+	end := strings.Index(e.SQL, ")")
+	if start < 0 || end < start {
+		return ans
+	}
+	for i, col := range strings.Split(e.SQL[start+1:end], ",") {
+		if i < len(e.Args) {
+			ans[strings.TrimSpace(col)] = e.Args[i]
+		}
+	}
+	return ans
+}
+
+// Writer is a ReportingWriter capturing all the written records.
+// Similarly to the TimescaleDB writer, records for tables not added
+// via AddTableWriter are dropped.
+type Writer struct {
+	mu      sync.Mutex
+	tables  map[string]*hltscl.TableWriter
+	entries []Entry
+	dropped []reporting.Timescalable
+	closed  bool
+}
+
+func (w *Writer) LogErrors() {
+}
+
+func (w *Writer) Write(item reporting.Timescalable) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	tw, ok := w.tables[item.GetTableName()]
+	if !ok || w.closed {
+		w.dropped = append(w.dropped, item)
+		return
+	}
+	sql, args := item.ToTimescaleDB(tw).ExportForSQL(item.GetTableName(), reporting.TimeColumnName)
+	w.entries = append(w.entries, Entry{
+		Table:  item.GetTableName(),
+		Record: item,
+		SQL:    sql,
+		Args:   args,
+	})
+}
+
+func (w *Writer) AddTableWriter(tableName string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.tables[tableName] = hltscl.NewTableWriter(nil, tableName, reporting.TimeColumnName, time.UTC)
+}
+
+func (w *Writer) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (w *Writer) QueueStats() []reporting.QueueStats {
+	return []reporting.QueueStats{}
+}
+
+func (w *Writer) Close(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+// Entries provides all the captured entries
+func (w *Writer) Entries() []Entry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]Entry{}, w.entries...)
+}
+
+// Dropped provides records written to unknown tables
+// or after the writer has been closed
+func (w *Writer) Dropped() []reporting.Timescalable {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]reporting.Timescalable{}, w.dropped...)
+}
+
+func NewWriter() *Writer {
+	return &Writer{tables: make(map[string]*hltscl.TableWriter)}
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package sqlite
+
+import (
+	"errors"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/general"
+)
+
+const (
+	dfltRetentionDays       = 30
+	dfltDownsampleAfterDays = 7
+	dfltDownsampleInterval  = general.Duration(5 * time.Minute)
+	dfltMaintenanceInterval = general.Duration(time.Hour)
+	dfltFlushInterval       = general.Duration(5 * time.Second)
+	dfltBufferSize          = 10000
+)
+
+// Conf configures storing of records in an embedded SQLite database
+type Conf struct {
+	Path string `json:"path"`
+
+	// RetentionDays specifies how long records are kept
+	// (a negative value disables pruning)
+	RetentionDays int `json:"retentionDays"`
+
+	// DownsampleAfterDays specifies the age of records which are
+	// aggregated into downsampleInterval buckets (a negative value
+	// disables downsampling)
+	DownsampleAfterDays int `json:"downsampleAfterDays"`
+
+	DownsampleInterval general.Duration `json:"downsampleInterval"`
+
+	// MaintenanceInterval specifies how often pruning
+	// and downsampling run
+	MaintenanceInterval general.Duration `json:"maintenanceInterval"`
+
+	// FlushInterval specifies how often pending records are written
+	// (all of them in a single transaction)
+	FlushInterval general.Duration `json:"flushInterval"`
+
+	// BufferSize is a maximum number of pending records. In case
+	// the database cannot be written for a long time (e.g. it is
+	// locked by another process), the oldest records are dropped.
+	BufferSize int `json:"bufferSize"`
+}
+
+func (conf *Conf) ValidateAndDefaults() error {
+	if conf == nil {
+		return nil
+	}
+	if conf.Path == "" {
+		return errors.New("sinks.sqlite.path is missing/empty")
+	}
+	if conf.DownsampleInterval < 0 || conf.MaintenanceInterval < 0 || conf.FlushInterval < 0 {
+		return errors.New("sinks.sqlite.downsampleInterval, maintenanceInterval and flushInterval must not be negative")
+	}
+	if conf.BufferSize < 0 {
+		return errors.New("sinks.sqlite.bufferSize must not be negative")
+	}
+	if conf.RetentionDays == 0 {
+		conf.RetentionDays = dfltRetentionDays
+	}
+	if conf.DownsampleAfterDays == 0 {
+		conf.DownsampleAfterDays = dfltDownsampleAfterDays
+	}
+	if conf.RetentionDays > 0 && conf.DownsampleAfterDays > conf.RetentionDays {
+		return errors.New("sinks.sqlite.downsampleAfterDays must not exceed retentionDays")
+	}
+	if conf.DownsampleInterval == 0 {
+		conf.DownsampleInterval = dfltDownsampleInterval
+	}
+	if conf.MaintenanceInterval == 0 {
+		conf.MaintenanceInterval = dfltMaintenanceInterval
+	}
+	if conf.FlushInterval == 0 {
+		conf.FlushInterval = dfltFlushInterval
+	}
+	if conf.BufferSize == 0 {
+		conf.BufferSize = dfltBufferSize
+	}
+	return nil
+}
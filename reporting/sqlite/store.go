@@ -0,0 +1,628 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// downsampledTable keeps the time until which records
+	// of each table have been downsampled
+	downsampledTable = "mariadb_tscl_downsampled"
+
+	// downsampleChunk is the maximum period of records aggregated
+	// in a single transaction so writes are not blocked for long
+	downsampleChunk = 24 * time.Hour
+)
+
+var ErrTableNotFound = errors.New("table not found")
+
+type columnRole int
+
+const (
+	roleTag columnRole = iota
+
+	// roleSum is used for counter differences (and intervals)
+	// which are summed when aggregated
+	roleSum
+
+	// roleMax is used for gauges which are replaced
+	// with the maximum when aggregated
+	roleMax
+
+	// roleAvg is used for averages (e.g. collection_time_avg_ms)
+	// which are averaged when aggregated
+	roleAvg
+)
+
+// fieldRole provides the aggregation role of a field
+// of a record with the specified counter fields
+func fieldRole(name string, counters []string) columnRole {
+	if slices.Contains(counters, name) || name == "interval_ms" {
+		return roleSum
+
+	} else if strings.HasSuffix(name, "_avg") || strings.Contains(name, "_avg_") {
+		return roleAvg
+	}
+	return roleMax
+}
+
+type column struct {
+	name    string
+	sqlType string
+	role    columnRole
+}
+
+// recordLayout provides columns (without the time column)
+// filled in by the records
+func recordLayout(records ...reporting.Timescalable) []column {
+	var ans []column
+	seen := make(map[string]bool)
+	for _, rec := range records {
+		tags, fields := rec.ToInfluxDB()
+		var counters []string
+		if cr, ok := rec.(reporting.CounterRecord); ok {
+			counters = cr.CounterFields()
+		}
+		for _, k := range slices.Sorted(maps.Keys(tags)) {
+			if !seen[k] {
+				ans = append(ans, column{name: k, sqlType: "TEXT", role: roleTag})
+				seen[k] = true
+			}
+		}
+		for _, k := range slices.Sorted(maps.Keys(fields)) {
+			if seen[k] {
+				continue
+			}
+			col := column{name: k, sqlType: "INTEGER", role: fieldRole(k, counters)}
+			switch fields[k].(type) {
+			case float64:
+				col.sqlType = "REAL"
+			case string:
+				col.sqlType = "TEXT"
+			}
+			ans = append(ans, col)
+			seen[k] = true
+		}
+	}
+	return ans
+}
+
+func quote(ident string) string {
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
+
+// Row is a single stored record
+type Row struct {
+	Time   time.Time
+	Values map[string]any
+}
+
+// Store is an SQLite database with the same tables as the reporting
+// database (see scripts/schema.sql) except for the time column which
+// contains Unix time in milliseconds. Tables are created and extended
+// with new columns automatically.
+type Store struct {
+	db *sql.DB
+
+	mu sync.Mutex
+
+	// columns contains known columns of existing tables
+	columns map[string]map[string]bool
+
+	// roles contains roles of columns of the records written so far
+	// (in addition to the records registered for the tables)
+	roles map[string]map[string]columnRole
+}
+
+// tableColumns reads names and types of the columns of the table.
+// For a nonexistent table, an empty map is returned.
+func (s *Store) tableColumns(ctx context.Context, table string) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT name, type FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+	ans := make(map[string]string)
+	for rows.Next() {
+		var name, tp string
+		if err := rows.Scan(&name, &tp); err != nil {
+			return nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+		}
+		ans[name] = tp
+	}
+	return ans, rows.Err()
+}
+
+// ensureTable creates the table or adds columns it is missing
+func (s *Store) ensureTable(ctx context.Context, table string, layout []column) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.roles[table] == nil {
+		s.roles[table] = make(map[string]columnRole)
+	}
+	for _, col := range layout {
+		s.roles[table][col.name] = col.role
+	}
+	known := s.columns[table]
+	if known != nil && !slices.ContainsFunc(layout, func(c column) bool { return !known[c.name] }) {
+		return nil
+	}
+	existing, err := s.tableColumns(ctx, table)
+	if err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		defs := []string{quote(reporting.TimeColumnName) + " INTEGER NOT NULL"}
+		for _, col := range layout {
+			defs = append(defs, quote(col.name)+" "+col.sqlType)
+		}
+		stmts := []string{
+			fmt.Sprintf("CREATE TABLE %s (%s)", quote(table), strings.Join(defs, ", ")),
+			fmt.Sprintf(
+				"CREATE INDEX %s ON %s (%s)",
+				quote(table+"_time_idx"), quote(table), quote(reporting.TimeColumnName),
+			),
+		}
+		for _, stmt := range stmts {
+			if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("failed to create table %s: %w", table, err)
+			}
+		}
+		existing[reporting.TimeColumnName] = "INTEGER"
+		for _, col := range layout {
+			existing[col.name] = col.sqlType
+		}
+
+	} else {
+		for _, col := range layout {
+			if _, ok := existing[col.name]; ok {
+				continue
+			}
+			_, err := s.db.ExecContext(
+				ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", quote(table), quote(col.name), col.sqlType))
+			if err != nil {
+				return fmt.Errorf("failed to add column %s to %s: %w", col.name, table, err)
+			}
+			existing[col.name] = col.sqlType
+		}
+	}
+	s.columns[table] = make(map[string]bool)
+	for name := range existing {
+		s.columns[table][name] = true
+	}
+	return nil
+}
+
+// Insert stores the records in a single transaction
+func (s *Store) Insert(ctx context.Context, items ...reporting.Timescalable) error {
+	// tables must be prepared before the transaction
+	// as the database uses a single connection
+	for _, item := range items {
+		if err := s.ensureTable(ctx, item.GetTableName(), recordLayout(item)); err != nil {
+			return err
+		}
+	}
+	stmts := make([]txStatement, 0, len(items))
+	for _, item := range items {
+		tags, fields := item.ToInfluxDB()
+		cols := []string{quote(reporting.TimeColumnName)}
+		args := []any{item.GetTime().UnixMilli()}
+		for _, k := range slices.Sorted(maps.Keys(tags)) {
+			cols = append(cols, quote(k))
+			args = append(args, tags[k])
+		}
+		for _, k := range slices.Sorted(maps.Keys(fields)) {
+			cols = append(cols, quote(k))
+			args = append(args, fields[k])
+		}
+		stmts = append(stmts, txStatement{
+			sql: fmt.Sprintf(
+				"INSERT INTO %s (%s) VALUES (%s)",
+				quote(item.GetTableName()),
+				strings.Join(cols, ", "),
+				strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", "),
+			),
+			args: args,
+		})
+	}
+	return s.execInTx(ctx, stmts)
+}
+
+// Query provides records of the table with the time within [from, to)
+// ordered by time. Optionally, records can be filtered by tag values.
+func (s *Store) Query(
+	ctx context.Context,
+	table string,
+	from, to time.Time,
+	tags map[string]string,
+) ([]Row, error) {
+	existing, err := s.tableColumns(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTableNotFound, table)
+	}
+	where := []string{quote(reporting.TimeColumnName) + " >= ?", quote(reporting.TimeColumnName) + " < ?"}
+	args := []any{from.UnixMilli(), to.UnixMilli()}
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		where = append(where, quote(k)+" = ?")
+		args = append(args, tags[k])
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		fmt.Sprintf(
+			"SELECT * FROM %s WHERE %s ORDER BY %s",
+			quote(table), strings.Join(where, " AND "), quote(reporting.TimeColumnName),
+		),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", table, err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var ans []Row
+	for rows.Next() {
+		values := make([]any, len(cols))
+		dest := make([]any, len(cols))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to query %s: %w", table, err)
+		}
+		row := Row{Values: make(map[string]any, len(cols)-1)}
+		for i, col := range cols {
+			if col == reporting.TimeColumnName {
+				ms, _ := values[i].(int64)
+				row.Time = time.UnixMilli(ms)
+
+			} else if values[i] != nil {
+				row.Values[col] = values[i]
+			}
+		}
+		ans = append(ans, row)
+	}
+	return ans, rows.Err()
+}
+
+// LoadStatusHistory aggregates status records of the instance stored
+// since the specified time the same way reporting.LoadStatusHistory
+// does for the reporting database.
+func (s *Store) LoadStatusHistory(
+	ctx context.Context,
+	instance string,
+	since time.Time,
+) (map[string]int64, int, error) {
+	existing, err := s.tableColumns(ctx, reporting.MariaDBTSCLStatusMonitoringTable)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(existing) == 0 {
+		return nil, 0, fmt.Errorf("%w: %s", ErrTableNotFound, reporting.MariaDBTSCLStatusMonitoringTable)
+	}
+	var fields []column
+	var exprs []string
+	for _, col := range recordLayout(&reporting.ConnectionsStatus{}) {
+		if _, ok := existing[col.name]; !ok {
+			continue // e.g. a field added after the records were written
+
+		} else if col.role == roleSum {
+			exprs = append(exprs, fmt.Sprintf("COALESCE(SUM(%s), 0)", quote(col.name)))
+
+		} else if col.role == roleMax {
+			exprs = append(exprs, fmt.Sprintf("COALESCE(MAX(%s), 0)", quote(col.name)))
+
+		} else {
+			continue
+		}
+		fields = append(fields, col)
+	}
+	var numRecords int
+	values := make([]int64, len(fields))
+	dest := []any{&numRecords}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	err = s.db.QueryRowContext(
+		ctx,
+		fmt.Sprintf(
+			"SELECT COUNT(*), %s FROM %s WHERE instance = ? AND %s >= ?",
+			strings.Join(exprs, ", "),
+			quote(reporting.MariaDBTSCLStatusMonitoringTable),
+			quote(reporting.TimeColumnName),
+		),
+		instance, since.UnixMilli(),
+	).Scan(dest...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load status history: %w", err)
+	}
+	ans := make(map[string]int64, len(fields))
+	for i, col := range fields {
+		ans[col.name] = values[i]
+	}
+	return ans, numRecords, nil
+}
+
+// dataTables provides names of all the tables with records
+func (s *Store) dataTables(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != ?",
+		downsampledTable,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ans []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		ans = append(ans, name)
+	}
+	return ans, rows.Err()
+}
+
+// Prune removes records older than the specified time
+func (s *Store) Prune(ctx context.Context, before time.Time) error {
+	tables, err := s.dataTables(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to prune records: %w", err)
+	}
+	for _, table := range tables {
+		_, err := s.db.ExecContext(
+			ctx,
+			fmt.Sprintf("DELETE FROM %s WHERE %s < ?", quote(table), quote(reporting.TimeColumnName)),
+			before.UnixMilli(),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to prune records of %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// Downsample aggregates records older than the specified time (and not
+// aggregated yet) into buckets of the specified size. Counter
+// differences are summed, averages are averaged and gauges
+// are replaced with their maximum.
+// Records are processed in chunks (see downsampleChunk), each
+// in its own transaction.
+func (s *Store) Downsample(ctx context.Context, until time.Time, bucket time.Duration) error {
+	tables, err := s.dataTables(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to downsample records: %w", err)
+	}
+	for _, table := range tables {
+		if err := s.downsampleTable(ctx, table, until, bucket); err != nil {
+			return fmt.Errorf("failed to downsample records of %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) downsampleTable(ctx context.Context, table string, until time.Time, bucket time.Duration) error {
+	existing, err := s.tableColumns(ctx, table)
+	if err != nil {
+		return err
+	}
+	roles := make(map[string]columnRole)
+	for _, col := range recordLayout(reporting.TableRecords(table)...) {
+		roles[col.name] = col.role
+	}
+	s.mu.Lock()
+	maps.Copy(roles, s.roles[table])
+	s.mu.Unlock()
+	bucketMs := bucket.Milliseconds()
+	timeCol := quote(reporting.TimeColumnName)
+	bucketExpr := fmt.Sprintf("(%s / %d) * %d", timeCol, bucketMs, bucketMs)
+	cols := []string{timeCol}
+	exprs := []string{bucketExpr}
+	groupBy := []string{bucketExpr}
+	for _, name := range slices.Sorted(maps.Keys(existing)) {
+		if name == reporting.TimeColumnName {
+			continue
+		}
+		role, ok := roles[name]
+		if !ok {
+			// columns of unknown records (e.g. removed fields)
+			role = fieldRole(name, nil)
+			if existing[name] == "TEXT" {
+				role = roleTag
+			}
+		}
+		cols = append(cols, quote(name))
+		switch role {
+		case roleTag:
+			exprs = append(exprs, quote(name))
+			groupBy = append(groupBy, quote(name))
+		case roleSum:
+			exprs = append(exprs, fmt.Sprintf("SUM(%s)", quote(name)))
+		case roleAvg:
+			exprs = append(exprs, fmt.Sprintf("AVG(%s)", quote(name)))
+		default:
+			exprs = append(exprs, fmt.Sprintf("MAX(%s)", quote(name)))
+		}
+	}
+
+	var from int64
+	err = s.db.QueryRowContext(
+		ctx, fmt.Sprintf("SELECT until FROM %s WHERE table_name = ?", downsampledTable), table,
+	).Scan(&from)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	to := until.Truncate(bucket).UnixMilli()
+	if to <= from {
+		return nil
+	}
+	// skip the period without records (e.g. since 1970 on the first run)
+	var first sql.NullInt64
+	err = s.db.QueryRowContext(
+		ctx,
+		fmt.Sprintf("SELECT MIN(%s) FROM %s WHERE %s >= ? AND %s < ?", timeCol, quote(table), timeCol, timeCol),
+		from, to,
+	).Scan(&first)
+	if err != nil {
+		return err
+	}
+	if first.Valid {
+		from = max(from, first.Int64/bucketMs*bucketMs)
+
+	} else {
+		from = to
+	}
+	chunkMs := max(downsampleChunk.Milliseconds()/bucketMs, 1) * bucketMs
+	for {
+		end := min(from+chunkMs, to)
+		stmts := []txStatement{
+			{
+				fmt.Sprintf(
+					"CREATE TEMP TABLE downsampled AS SELECT %s FROM %s WHERE %s >= ? AND %s < ? GROUP BY %s",
+					strings.Join(exprs, ", "), quote(table), timeCol, timeCol, strings.Join(groupBy, ", "),
+				),
+				[]any{from, end},
+			},
+			{
+				fmt.Sprintf("DELETE FROM %s WHERE %s >= ? AND %s < ?", quote(table), timeCol, timeCol),
+				[]any{from, end},
+			},
+			{
+				fmt.Sprintf(
+					"INSERT INTO %s (%s) SELECT * FROM temp.downsampled",
+					quote(table), strings.Join(cols, ", "),
+				),
+				nil,
+			},
+			{"DROP TABLE temp.downsampled", nil},
+			{
+				fmt.Sprintf(
+					"INSERT INTO %s (table_name, until) VALUES (?, ?) "+
+						"ON CONFLICT (table_name) DO UPDATE SET until = excluded.until",
+					downsampledTable,
+				),
+				[]any{table, end},
+			},
+		}
+		if err := s.execInTx(ctx, stmts); err != nil {
+			return err
+		}
+		if end >= to {
+			return nil
+		}
+		from = end
+	}
+}
+
+type txStatement struct {
+	sql  string
+	args []any
+}
+
+// execInTx runs the statements in a single transaction
+func (s *Store) execInTx(ctx context.Context, stmts []txStatement) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt.sql, stmt.args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Open opens (or creates) the database. The database uses
+// the WAL mode so it can be read by other processes (e.g.
+// the `advise` action) while records are being written.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open(
+		"sqlite",
+		"file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	// a single connection prevents the writer from locking itself out
+	// (and it is required by the temporary table used for downsampling)
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (table_name TEXT PRIMARY KEY, until INTEGER NOT NULL)",
+		downsampledTable,
+	))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize SQLite database: %w", err)
+	}
+	return &Store{
+		db:      db,
+		columns: make(map[string]map[string]bool),
+		roles:   make(map[string]map[string]columnRole),
+	}, nil
+}
+
+// OpenReadOnly opens an existing database for reading only
+// (e.g. for the `advise` action). Unlike Open, it fails if
+// the database does not exist.
+func OpenReadOnly(path string) (*Store, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	return &Store{
+		db:      db,
+		columns: make(map[string]map[string]bool),
+		roles:   make(map[string]map[string]columnRole),
+	}, nil
+}
@@ -0,0 +1,200 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package sqlite provides a reporting writer storing records
+// in an embedded SQLite database. It is intended for single-box
+// setups where running TimescaleDB would be too heavy. Old records
+// are downsampled and pruned automatically and the stored history
+// can be read via Store (e.g. by the `advise` action).
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/rs/zerolog/log"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Writer stores records in batches (see reporting.BatchQueue)
+// so collectors are not blocked by the database (e.g. during
+// the maintenance) and runs the maintenance (pruning and
+// downsampling) in the configured interval
+type Writer struct {
+	reporting.BaseWriter
+	conf  *Conf
+	store *Store
+	queue *reporting.BatchQueue[reporting.Timescalable]
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// AddTableWriter creates the table (or adds its missing columns)
+// according to the record types registered for the table
+func (w *Writer) AddTableWriter(tableName string) {
+	layout := recordLayout(reporting.TableRecords(tableName)...)
+	if len(layout) == 0 {
+		return
+	}
+	if err := w.store.ensureTable(context.Background(), tableName, layout); err != nil {
+		log.Error().Err(err).Str("table_name", tableName).Msg("failed to prepare SQLite table")
+	}
+}
+
+func (w *Writer) Write(item reporting.Timescalable) {
+	w.queue.Push(item)
+}
+
+// insert stores a batch of records. Only a busy or locked database
+// is considered to be a transient problem.
+func (w *Writer) insert(ctx context.Context, batch []reporting.Timescalable) error {
+	err := w.store.Insert(ctx, batch...)
+	if err != nil && ctx.Err() == nil && !isBusy(err) {
+		return &reporting.PermanentError{Err: err}
+	}
+	return err
+}
+
+// isBusy tests whether the error is caused by the database
+// being locked by another connection
+func isBusy(err error) bool {
+	var serr *sqlite.Error
+	if !errors.As(err, &serr) {
+		return false
+	}
+	code := serr.Code() & 0xff // the primary result code
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// maintain prunes and downsamples records according
+// to the configuration
+func (w *Writer) maintain(ctx context.Context, now time.Time) {
+	if w.conf.RetentionDays > 0 {
+		if err := w.store.Prune(ctx, now.AddDate(0, 0, -w.conf.RetentionDays)); err != nil {
+			log.Error().Err(err).Msg("SQLite maintenance failed")
+		}
+	}
+	if w.conf.DownsampleAfterDays > 0 {
+		err := w.store.Downsample(
+			ctx, now.AddDate(0, 0, -w.conf.DownsampleAfterDays), w.conf.DownsampleInterval.Duration())
+		if err != nil {
+			log.Error().Err(err).Msg("SQLite maintenance failed")
+		}
+	}
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-w.stop
+		cancel()
+	}()
+	ticker := time.NewTicker(w.conf.MaintenanceInterval.Duration())
+	defer ticker.Stop()
+	w.maintain(ctx, time.Now())
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.maintain(ctx, time.Now())
+		}
+	}
+}
+
+// Store provides the underlying database, e.g. for reading
+// stored records
+func (w *Writer) Store() *Store {
+	return w.store
+}
+
+// QueueStats provides state of the buffer of pending records
+func (w *Writer) QueueStats() []reporting.QueueStats {
+	return []reporting.QueueStats{w.queue.Stats()}
+}
+
+// Flush requests writing of all the pending records and waits
+// until they are written or until the context is done
+func (w *Writer) Flush(ctx context.Context) error {
+	return w.queue.Flush(ctx)
+}
+
+// Close stops accepting new records, writes the pending ones,
+// stops the maintenance and closes the database
+func (w *Writer) Close(ctx context.Context) error {
+	err := w.queue.Close(ctx)
+	select {
+	case <-w.stop:
+		return err
+	default:
+	}
+	close(w.stop)
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		return errors.Join(err, fmt.Errorf("failed to stop SQLite maintenance: %w", ctx.Err()))
+	}
+	return errors.Join(err, w.store.Close())
+}
+
+// newWriter creates a writer without starting the maintenance loop
+func newWriter(conf *Conf, store *Store) *Writer {
+	ans := &Writer{
+		conf:  conf,
+		store: store,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	ans.queue = reporting.NewBatchQueue(
+		reporting.BatchQueueConf[reporting.Timescalable]{
+			Name:          "sqlite:" + conf.Path,
+			BufferSize:    conf.BufferSize,
+			FlushInterval: conf.FlushInterval.Duration(),
+		},
+		ans.insert,
+	)
+	return ans
+}
+
+// NewWriter opens the database and starts the maintenance loop.
+// The configuration is expected to be validated.
+func NewWriter(conf *Conf) (*Writer, error) {
+	store, err := Open(conf.Path)
+	if err != nil {
+		return nil, err
+	}
+	ans := newWriter(conf, store)
+	go ans.run()
+	return ans, nil
+}
+
+// CheckDB tests whether the database can be opened and written
+func CheckDB(conf *Conf) error {
+	store, err := Open(conf.Path)
+	if err != nil {
+		return err
+	}
+	return store.Close()
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestWriter creates a writer without the maintenance loop
+// so the maintenance can be run with a specific time
+func newTestWriter(t *testing.T) *Writer {
+	conf := &Conf{Path: filepath.Join(t.TempDir(), "history.db")}
+	require.NoError(t, conf.ValidateAndDefaults())
+	store, err := Open(conf.Path)
+	require.NoError(t, err)
+	w := newWriter(conf, store)
+	close(w.done)
+	t.Cleanup(func() { w.Close(context.Background()) })
+	return w
+}
+
+func statusRecord(created time.Time, instance string, comSelect, threads int) *reporting.ConnectionsStatus {
+	return &reporting.ConnectionsStatus{
+		Created:    created,
+		Instance:   instance,
+		IntervalMs: 60000,
+		Status:     db.Status{ComSelect: comSelect, ThreadsConnected: threads},
+	}
+}
+
+func TestWriteAndQuery(t *testing.T) {
+	w := newTestWriter(t)
+	w.AddTableWriter(reporting.MariaDBTSCLStatusMonitoringTable)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		w.Write(statusRecord(start.Add(time.Duration(i)*time.Minute), "db1", 10*i, i))
+	}
+	w.Write(statusRecord(start, "db2", 100, 1))
+	w.Write(&reporting.CollectorSelfStatus{Created: start, Instance: "db1", Collector: "global_status", Collections: 6})
+	require.NoError(t, w.Flush(context.Background()))
+	assert.Equal(t, int64(0), w.QueueStats()[0].WriteErrors)
+
+	rows, err := w.Store().Query(
+		context.Background(),
+		reporting.MariaDBTSCLStatusMonitoringTable,
+		start.Add(time.Minute), start.Add(3*time.Minute),
+		map[string]string{"instance": "db1"},
+	)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.True(t, rows[0].Time.Equal(start.Add(time.Minute)))
+	assert.Equal(t, int64(10), rows[0].Values["com_select"])
+	assert.Equal(t, "db1", rows[1].Values["instance"])
+
+	history, numRecords, err := w.Store().LoadStatusHistory(context.Background(), "db1", start)
+	require.NoError(t, err)
+	assert.Equal(t, 5, numRecords)
+	assert.Equal(t, int64(100), history["com_select"])
+	assert.Equal(t, int64(4), history["threads_connected"])
+	assert.Equal(t, int64(300000), history["interval_ms"])
+
+	_, err = w.Store().Query(context.Background(), "nonexistent", start, start, nil)
+	assert.ErrorIs(t, err, ErrTableNotFound)
+}
+
+func TestMaintenance(t *testing.T) {
+	w := newTestWriter(t)
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	old := now.AddDate(0, 0, -10).Truncate(time.Hour)
+	for i := 0; i < 10; i++ {
+		w.Write(statusRecord(old.Add(time.Duration(i)*time.Minute), "db1", 1, i))
+	}
+	w.Write(statusRecord(now.AddDate(0, 0, -40), "db1", 1, 1))
+	w.Write(statusRecord(now.Add(-time.Minute), "db1", 1, 1))
+	require.NoError(t, w.Flush(context.Background()))
+
+	w.maintain(context.Background(), now)
+	// repeated maintenance must not aggregate the records again
+	w.maintain(context.Background(), now)
+
+	rows, err := w.Store().Query(
+		context.Background(), reporting.MariaDBTSCLStatusMonitoringTable, time.Time{}, now, nil)
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	// 5 minute buckets
+	assert.True(t, rows[0].Time.Equal(old))
+	assert.Equal(t, int64(5), rows[0].Values["com_select"])
+	assert.Equal(t, int64(4), rows[0].Values["threads_connected"])
+	assert.Equal(t, int64(300000), rows[0].Values["interval_ms"])
+	assert.Equal(t, "db1", rows[0].Values["instance"])
+	assert.True(t, rows[1].Time.Equal(old.Add(5*time.Minute)))
+	assert.Equal(t, int64(9), rows[1].Values["threads_connected"])
+	assert.True(t, rows[2].Time.Equal(now.Add(-time.Minute)))
+}
+
+func TestDownsampleAverages(t *testing.T) {
+	w := newTestWriter(t)
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	old := now.AddDate(0, 0, -10).Truncate(time.Hour)
+	for i, avg := range []float64{10, 20, 60} {
+		w.Write(&reporting.CollectorSelfStatus{
+			Created:           old.Add(time.Duration(i) * time.Minute),
+			Instance:          "db1",
+			Collector:         "global_status",
+			Collections:       6,
+			CollectionTimeAvg: avg,
+			CollectionTimeMax: 2 * avg,
+		})
+	}
+	require.NoError(t, w.Flush(context.Background()))
+	w.maintain(context.Background(), now)
+
+	rows, err := w.Store().Query(context.Background(), reporting.MariaDBTSCLSelfTable, time.Time{}, now, nil)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, int64(18), rows[0].Values["collections"])
+	assert.Equal(t, float64(30), rows[0].Values["collection_time_avg_ms"])
+	assert.Equal(t, float64(120), rows[0].Values["collection_time_max_ms"])
+}
+
+func TestDownsampleInChunks(t *testing.T) {
+	w := newTestWriter(t)
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	days := []time.Time{now.AddDate(0, 0, -20).Truncate(time.Hour), now.AddDate(0, 0, -10).Truncate(time.Hour)}
+	for _, day := range days {
+		w.Write(statusRecord(day, "db1", 1, 1))
+		w.Write(statusRecord(day.Add(time.Minute), "db1", 2, 3))
+	}
+	require.NoError(t, w.Flush(context.Background()))
+	w.maintain(context.Background(), now)
+
+	rows, err := w.Store().Query(
+		context.Background(), reporting.MariaDBTSCLStatusMonitoringTable, time.Time{}, now, nil)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	for i, row := range rows {
+		assert.True(t, row.Time.Equal(days[i]))
+		assert.Equal(t, int64(3), row.Values["com_select"])
+		assert.Equal(t, int64(3), row.Values["threads_connected"])
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	_, err := OpenReadOnly(path)
+	assert.Error(t, err)
+	assert.NoFileExists(t, path)
+
+	store, err := Open(path)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	store, err = OpenReadOnly(path)
+	require.NoError(t, err)
+	defer store.Close()
+	_, _, err = store.LoadStatusHistory(context.Background(), "db1", time.Time{})
+	assert.ErrorIs(t, err, ErrTableNotFound)
+}
@@ -0,0 +1,47 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package textfile
+
+import (
+	"errors"
+	"path/filepath"
+)
+
+// Conf configures writing of the latest metrics to a file
+// read by node_exporter's textfile collector
+type Conf struct {
+
+	// Path is a path of the output file. It must be located in the
+	// directory configured via node_exporter's
+	// --collector.textfile.directory and it must have the .prom suffix.
+	Path string `json:"path"`
+}
+
+func (conf *Conf) ValidateAndDefaults() error {
+	if conf == nil {
+		return nil
+	}
+	if conf.Path == "" {
+		return errors.New("sinks.textfile.path is missing/empty")
+	}
+	if filepath.Ext(conf.Path) != ".prom" {
+		return errors.New("sinks.textfile.path must have the .prom suffix")
+	}
+	return nil
+}
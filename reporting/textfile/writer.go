@@ -0,0 +1,242 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package textfile provides a reporting writer exposing the latest
+// collected values in the Prometheus text format for node_exporter's
+// textfile collector. Each field of a record becomes a gauge named
+// <table>_<field> with the record's tags as labels. Counters of records
+// able to provide their cumulative values (see reporting.CumulativeRecord)
+// are exported as counters named <table>_<field>_total instead.
+package textfile
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/rs/zerolog/log"
+)
+
+var labelValueEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+// sample is the latest value of a single time series
+type sample struct {
+	name    string
+	labels  string
+	value   float64
+	counter bool
+}
+
+// Writer keeps the latest value of each time series and rewrites
+// the output file once all the records of a collector run are
+// written (see reporting.BatchWriter). The file is replaced atomically
+// so node_exporter never reads a partially written file.
+type Writer struct {
+	reporting.BaseWriter
+	conf *Conf
+
+	mu          sync.Mutex
+	samples     map[string]sample
+	help        map[string]string
+	writeErrors int64
+	closed      bool
+}
+
+func (w *Writer) Write(item reporting.Timescalable) {
+	w.WriteBatch([]reporting.Timescalable{item})
+}
+
+// WriteBatch updates the samples with all the records
+// and rewrites the output file
+func (w *Writer) WriteBatch(items []reporting.Timescalable) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		log.Warn().Int("records", len(items)).Msg("textfile writer closed, records dropped")
+		return
+	}
+	for _, item := range items {
+		w.update(item)
+	}
+	if err := w.writeFile(); err != nil {
+		w.writeErrors++
+		log.Error().Err(err).Str("path", w.conf.Path).Msg("failed to write textfile metrics")
+	}
+}
+
+// update sets samples of all the time series of the record
+func (w *Writer) update(item reporting.Timescalable) {
+	tags, fields := item.ToInfluxDB()
+	labels := formatLabels(tags)
+	table := metricName(item.GetTableName())
+	// differences of counters make no sense as Prometheus
+	// counters, only the cumulative values are exported
+	var cumulative map[string]any
+	if cr, ok := item.(reporting.CumulativeRecord); ok {
+		if _, values, ok := cr.CumulativeValues(); ok {
+			cumulative = values
+			maps.Copy(fields, values)
+		}
+	}
+	for field, v := range fields {
+		value, ok := reporting.NumericValue(v)
+		if !ok {
+			continue
+		}
+		name := table + "_" + metricName(field)
+		_, counter := cumulative[field]
+		if counter {
+			name += "_total"
+			w.help[name] = fmt.Sprintf("MariaDB-TSCL total of %s.%s", item.GetTableName(), field)
+
+		} else {
+			w.help[name] = fmt.Sprintf("MariaDB-TSCL value of %s.%s", item.GetTableName(), field)
+		}
+		w.samples[name+labels] = sample{name: name, labels: labels, value: value, counter: counter}
+	}
+	tsName := table + "_last_update_timestamp_seconds"
+	w.samples[tsName+labels] = sample{
+		name:   tsName,
+		labels: labels,
+		value:  float64(item.GetTime().UnixMilli()) / 1000,
+	}
+	w.help[tsName] = fmt.Sprintf("Time of the latest %s record", item.GetTableName())
+}
+
+// render encodes all the samples in the Prometheus text format
+func (w *Writer) render() []byte {
+	var buf bytes.Buffer
+	samples := slices.SortedFunc(maps.Values(w.samples), func(a, b sample) int {
+		return cmp.Or(strings.Compare(a.name, b.name), strings.Compare(a.labels, b.labels))
+	})
+	var prevName string
+	for _, s := range samples {
+		if s.name != prevName {
+			fmt.Fprintf(&buf, "# HELP %s %s\n", s.name, w.help[s.name])
+			if s.counter {
+				fmt.Fprintf(&buf, "# TYPE %s counter\n", s.name)
+
+			} else {
+				fmt.Fprintf(&buf, "# TYPE %s gauge\n", s.name)
+			}
+			prevName = s.name
+		}
+		fmt.Fprintf(&buf, "%s%s %s\n", s.name, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+	buf.WriteString("# EOF\n")
+	return buf.Bytes()
+}
+
+// writeFile writes all the samples to a temporary file
+// and renames it to the configured path
+func (w *Writer) writeFile() error {
+	dir, name := filepath.Split(w.conf.Path)
+	tmp, err := os.CreateTemp(dir, "."+name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(w.render()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), w.conf.Path)
+}
+
+// QueueStats provides the number of failed updates of the file
+// (there is no queue as the file is written synchronously)
+func (w *Writer) QueueStats() []reporting.QueueStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return []reporting.QueueStats{
+		{Table: "textfile:" + w.conf.Path, WriteErrors: w.writeErrors},
+	}
+}
+
+// Flush does nothing as the file is written synchronously
+func (w *Writer) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close stops accepting new records. The file is kept
+// in place with the latest values.
+func (w *Writer) Close(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+func NewWriter(conf *Conf) *Writer {
+	return &Writer{
+		conf:    conf,
+		samples: make(map[string]sample),
+		help:    make(map[string]string),
+	}
+}
+
+// CheckPath tests whether the output file can be created
+func CheckPath(conf *Conf) error {
+	dir, name := filepath.Split(conf.Path)
+	tmp, err := os.CreateTemp(dir, "."+name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// ----
+
+// metricName replaces characters not allowed in metric
+// and label names with underscores
+func metricName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func formatLabels(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	items := make([]string, 0, len(tags))
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		items = append(items, fmt.Sprintf("%s=\"%s\"", metricName(k), labelValueEscaper.Replace(tags[k])))
+	}
+	return "{" + strings.Join(items, ",") + "}"
+}
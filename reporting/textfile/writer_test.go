@@ -0,0 +1,108 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package textfile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteLatestValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mariadb.prom")
+	w := NewWriter(&Conf{Path: path})
+	created := time.Unix(1700000000, 500000000)
+	w.Write(&reporting.ConnectionsStatus{
+		Created: created, Instance: "db1", Status: db.Status{ComSelect: 10, ThreadsConnected: 3}})
+	w.Write(&reporting.ConnectionsStatus{
+		Created: created, Instance: `db"2`, Status: db.Status{ComSelect: 7}})
+	w.Write(&reporting.ConnectionsStatus{
+		Created: created, Instance: "db1", Status: db.Status{ComSelect: 12, ThreadsConnected: 4}})
+	require.NoError(t, w.Close(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	out := string(data)
+	assert.Contains(t, out, "# TYPE mariadb_tscl_status_monitoring_com_select gauge\n"+
+		"mariadb_tscl_status_monitoring_com_select{instance=\"db1\"} 12\n"+
+		"mariadb_tscl_status_monitoring_com_select{instance=\"db\\\"2\"} 7\n")
+	assert.Contains(t, out, "mariadb_tscl_status_monitoring_threads_connected{instance=\"db1\"} 4\n")
+	assert.Contains(t, out, "mariadb_tscl_status_monitoring_last_update_timestamp_seconds{instance=\"db1\"} 1.7000000005e+09\n")
+	assert.Equal(t, 1, countOccurrences(out, "# HELP mariadb_tscl_status_monitoring_com_select "))
+	assert.Regexp(t, "# EOF\n$", out)
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestWriteCountersAsTotals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mariadb.prom")
+	w := NewWriter(&Conf{Path: path})
+	created := time.Unix(1700000000, 0)
+	w.Write(&reporting.ConnectionsStatus{
+		Created:     created,
+		Instance:    "db1",
+		Status:      db.Status{ComSelect: 10, ThreadsConnected: 3},
+		Totals:      &db.Status{ComSelect: 12345, ThreadsConnected: 3},
+		TotalsStart: created.Add(-time.Hour),
+	})
+	require.NoError(t, w.Close(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	out := string(data)
+	assert.Contains(t, out, "# TYPE mariadb_tscl_status_monitoring_com_select_total counter\n"+
+		"mariadb_tscl_status_monitoring_com_select_total{instance=\"db1\"} 12345\n")
+	assert.Contains(t, out, "# TYPE mariadb_tscl_status_monitoring_threads_connected gauge\n"+
+		"mariadb_tscl_status_monitoring_threads_connected{instance=\"db1\"} 3\n")
+	assert.NotContains(t, out, "mariadb_tscl_status_monitoring_com_select{")
+}
+
+func TestWriteBatchWritesFileOnce(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(&Conf{Path: filepath.Join(dir, "mariadb.prom")})
+	created := time.Unix(1700000000, 0)
+	// the output directory disappears so each file write fails
+	require.NoError(t, os.Remove(dir))
+	w.WriteBatch([]reporting.Timescalable{
+		&reporting.ConnectionsStatus{Created: created, Instance: "db1"},
+		&reporting.ConnectionsStatus{Created: created, Instance: "db2"},
+		&reporting.ConnectionsStatus{Created: created, Instance: "db3"},
+	})
+	assert.Equal(t, int64(1), w.QueueStats()[0].WriteErrors)
+}
+
+func countOccurrences(s, sub string) int {
+	n := 0
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if s[i:i+len(sub)] == sub {
+			n++
+		}
+	}
+	return n
+}
@@ -20,71 +20,381 @@ package reporting
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/czcorpus/hltscl"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	// flushPollInterval specifies how often Flush checks
+	// for pending entries
+	flushPollInterval = 50 * time.Millisecond
+
+	// writeRetryMinDelay and writeRetryMaxDelay limit delays
+	// between attempts to write to an unavailable database
+	writeRetryMinDelay = time.Second
+	writeRetryMaxDelay = 30 * time.Second
+)
+
+type writeError struct {
+	stmt statement
+	err  error
+}
+
 type Table struct {
-	writer    *hltscl.TableWriter
-	opsDataCh chan<- hltscl.Entry
-	errCh     <-chan hltscl.WriteError
+	name   string
+	writer *hltscl.TableWriter
+	queue  *tableQueue
+	errCh  chan writeError
+	done   chan struct{}
+
+	writeErrors atomic.Int64
+}
+
+// isRetryableWriteError tests whether a failed statement may
+// succeed later - i.e. the database is unreachable or temporarily
+// unable to process it (as opposed to refusing the statement itself)
+func isRetryableWriteError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return true
+	}
+	// connection exception, insufficient resources, operator intervention
+	return strings.HasPrefix(pgErr.Code, "08") ||
+		strings.HasPrefix(pgErr.Code, "53") ||
+		strings.HasPrefix(pgErr.Code, "57")
+}
+
+// run writes queued entries to the database until the queue
+// is closed and fully drained or until the context is cancelled.
+// Statements which cannot be written because of an unavailable
+// database are returned to the queue and retried later. The ones
+// not written before the context is cancelled are kept in the spill
+// file (if the queue uses one).
+func (table *Table) run(ctx context.Context, conn *pgxpool.Pool) {
+	defer close(table.done)
+	defer close(table.errCh)
+	defer table.queue.release()
+	retryDelay := writeRetryMinDelay
+	for ctx.Err() == nil {
+		stmt, ok := table.queue.pop()
+		if !ok {
+			return
+		}
+		_, err := conn.Exec(ctx, stmt.SQL, stmt.Args...)
+		if err == nil {
+			if retryDelay > writeRetryMinDelay {
+				log.Info().Str("table", table.name).Msg("reporting database available again")
+				retryDelay = writeRetryMinDelay
+			}
+			if stmt.written != nil {
+				stmt.written()
+			}
+			table.queue.done()
+			continue
+		}
+		if ctx.Err() != nil || isRetryableWriteError(err) {
+			table.queue.requeue(stmt)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warn().
+				Err(err).
+				Str("table", table.name).
+				Dur("retryIn", retryDelay).
+				Msg("failed to write data to TimescaleDB, will retry")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+			retryDelay = min(2*retryDelay, writeRetryMaxDelay)
+			continue
+		}
+		select {
+		case table.errCh <- writeError{stmt: stmt, err: err}:
+		default:
+			table.writeErrors.Add(1)
+			log.Error().Err(err).Msg("error writing data to TimescaleDB (error queue full)")
+		}
+		table.queue.done()
+	}
 }
 
 type TimescaleDBWriter struct {
-	ctx    context.Context
-	tz     *time.Location
-	conn   *pgxpool.Pool
-	tables map[string]*Table
+	ctx       context.Context
+	tz        *time.Location
+	conn      *pgxpool.Pool
+	queueConf QueueConf
+	layout    string
+	tables    map[string]*Table
+
+	// knownMetrics contains metrics already registered
+	// in the metric dictionary (narrow layout only)
+	knownMetrics   map[string]bool
+	knownMetricsMu sync.Mutex
+
+	// writeCtx is used for database writes; it is cancelled only
+	// if pending writes cannot be finished during Close
+	writeCtx    context.Context
+	cancelWrite context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// tableList provides all the tables. Tables may be added while
+// the writer is in use so the map must not be accessed directly.
+func (sw *TimescaleDBWriter) tableList() []*Table {
+	sw.mu.RLock()
+	defer sw.mu.RUnlock()
+	return slices.Collect(maps.Values(sw.tables))
 }
 
 func (sw *TimescaleDBWriter) LogErrors() {
-	for name, table := range sw.tables {
+	for _, table := range sw.tableList() {
 		go func(name string, table *Table) {
 			for {
 				select {
 				case <-sw.ctx.Done():
 					log.Info().Msgf("about to close %s status writer", name)
 					return
-				case err := <-table.errCh:
+				case err, ok := <-table.errCh:
+					if !ok {
+						log.Info().Msgf("%s status writer closed", name)
+						return
+					}
+					table.writeErrors.Add(1)
 					log.Error().
-						Err(err.Err).
-						Str("entry", err.Entry.String()).
+						Err(err.err).
+						Str("sql", err.stmt.SQL).
+						Any("args", err.stmt.Args).
 						Msg("error writing data to TimescaleDB")
-					fmt.Println("reporting timescale write err: ", err.Err)
 				}
 			}
-		}(name, table)
+		}(table.name, table)
 	}
 }
 
+// Write queues the item for writing. Depending on the configured
+// overflow policy, the call may block if the respective queue is full.
 func (sw *TimescaleDBWriter) Write(item Timescalable) {
+	sw.mu.RLock()
+	defer sw.mu.RUnlock()
+	if sw.closed {
+		log.Warn().Str("table_name", item.GetTableName()).Msg("Write to a closed writer, record dropped")
+		return
+	}
+	if sw.layout == LayoutNarrow {
+		sw.writeNarrow(item)
+		return
+	}
 	table, ok := sw.tables[item.GetTableName()]
 	if ok {
-		table.opsDataCh <- *item.ToTimescaleDB(table.writer)
+		sql, args := item.ToTimescaleDB(table.writer).ExportForSQL(table.name, TimeColumnName)
+		table.queue.push(statement{SQL: sql, Args: args})
 	} else {
 		log.Warn().Str("table_name", item.GetTableName()).Msg("Undefined table name in writer")
 	}
 }
 
+// writeNarrow queues the item for writing to the narrow table.
+// Metrics not seen before are registered in the metric dictionary
+// first. They are considered known only once the registration is
+// written so it is repeated with the next records until then.
+func (sw *TimescaleDBWriter) writeNarrow(item Timescalable) {
+	table, ok := sw.tables[MariaDBTSCLMetricsTable]
+	if !ok {
+		log.Warn().Str("table_name", item.GetTableName()).Msg("Undefined table name in writer")
+		return
+	}
+	stmt, metrics := narrowStatement(item, sw.tz)
+	if len(metrics) == 0 {
+		return
+	}
+	sw.knownMetricsMu.Lock()
+	newMetrics := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		if !sw.knownMetrics[m] {
+			newMetrics = append(newMetrics, m)
+		}
+	}
+	sw.knownMetricsMu.Unlock()
+	if len(newMetrics) > 0 {
+		dictStmt := metricDictStatement(item, newMetrics)
+		dictStmt.written = func() {
+			sw.knownMetricsMu.Lock()
+			defer sw.knownMetricsMu.Unlock()
+			for _, m := range newMetrics {
+				sw.knownMetrics[m] = true
+			}
+		}
+		table.queue.push(dictStmt)
+	}
+	table.queue.push(stmt)
+}
+
+// AddTableWriter prepares writing to the table. In the narrow layout,
+// records of all the tables are written to the same table.
 func (sw *TimescaleDBWriter) AddTableWriter(tableName string) {
-	twriter := hltscl.NewTableWriter(sw.conn, tableName, "time", sw.tz)
-	opsDataCh, errCh := twriter.Activate()
-	sw.tables[tableName] = &Table{
-		writer:    twriter,
-		opsDataCh: opsDataCh,
-		errCh:     errCh,
+	if sw.layout == LayoutNarrow {
+		if _, ok := sw.tables[MariaDBTSCLMetricsTable]; ok {
+			return
+		}
+		tableName = MariaDBTSCLMetricsTable
+	}
+	queue, err := newTableQueue(tableName, sw.queueConf)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to initialize table queue, falling back to drop-newest")
+		conf := sw.queueConf
+		conf.Overflow = OverflowDropNewest
+		queue, _ = newTableQueue(tableName, conf)
+	}
+	table := &Table{
+		name:   tableName,
+		writer: hltscl.NewTableWriter(sw.conn, tableName, TimeColumnName, sw.tz),
+		queue:  queue,
+		errCh:  make(chan writeError, sw.queueConf.Size),
+		done:   make(chan struct{}),
+	}
+	sw.mu.Lock()
+	sw.tables[tableName] = table
+	sw.mu.Unlock()
+	go table.run(sw.writeCtx, sw.conn)
+}
+
+// QueueStats provides actual state of all the table queues
+func (sw *TimescaleDBWriter) QueueStats() []QueueStats {
+	sw.mu.RLock()
+	defer sw.mu.RUnlock()
+	ans := make([]QueueStats, 0, len(sw.tables))
+	for _, table := range sw.tables {
+		stats := table.queue.stats()
+		stats.WriteErrors = table.writeErrors.Load()
+		ans = append(ans, stats)
+	}
+	return ans
+}
+
+func (sw *TimescaleDBWriter) numPending() int {
+	sw.mu.RLock()
+	defer sw.mu.RUnlock()
+	var ans int
+	for _, table := range sw.tables {
+		ans += table.queue.stats().Depth
+	}
+	return ans
+}
+
+// Flush waits until all the entries accepted so far are written
+// or until the context is done.
+func (sw *TimescaleDBWriter) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+	for _, table := range sw.tableList() {
+		for !table.queue.idle() {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("failed to flush %d pending entries: %w", sw.numPending(), ctx.Err())
+			case <-ticker.C:
+			}
+		}
 	}
+	return nil
 }
 
-func NewReportingWriter(connection *pgxpool.Pool, tz *time.Location, ctx context.Context) *TimescaleDBWriter {
+// Close stops accepting new entries and waits until all the pending
+// ones are written. If the context is done before that, unfinished
+// writes are cancelled and an error is returned (with the "spill"
+// overflow policy, unwritten entries are kept in the spill file).
+// The database connection pool is not closed by the writer.
+func (sw *TimescaleDBWriter) Close(ctx context.Context) error {
+	// queues are closed first to release writers possibly
+	// blocked by the "block" overflow policy
+	tables := sw.tableList()
+	for _, table := range tables {
+		table.queue.close()
+	}
+	sw.mu.Lock()
+	if sw.closed {
+		sw.mu.Unlock()
+		return nil
+	}
+	sw.closed = true
+	sw.mu.Unlock()
+
+	defer sw.cancelWrite()
+	for _, table := range tables {
+		select {
+		case <-table.done:
+		case <-ctx.Done():
+			return fmt.Errorf(
+				"failed to write %d pending entries (table %s and possibly others): %w",
+				sw.numPending(), table.name, ctx.Err(),
+			)
+		}
+	}
+	return nil
+}
+
+func NewReportingWriter(
+	connection *pgxpool.Pool,
+	tz *time.Location,
+	queueConf QueueConf,
+	layout string,
+	ctx context.Context,
+) *TimescaleDBWriter {
+	writeCtx, cancelWrite := context.WithCancel(context.Background())
 	return &TimescaleDBWriter{
-		ctx:    ctx,
-		tz:     tz,
-		conn:   connection,
-		tables: make(map[string]*Table),
+		ctx:          ctx,
+		tz:           tz,
+		conn:         connection,
+		queueConf:    queueConf,
+		layout:       layout,
+		tables:       make(map[string]*Table),
+		knownMetrics: make(map[string]bool),
+		writeCtx:     writeCtx,
+		cancelWrite:  cancelWrite,
+	}
+}
+
+// newEntry creates a TimescaleDB entry out of tags and fields
+// as provided by records' ToInfluxDB methods.
+func newEntry(
+	tableWriter *hltscl.TableWriter,
+	t time.Time,
+	tags map[string]string,
+	fields map[string]any,
+) *hltscl.Entry {
+	entry := tableWriter.NewEntry(t)
+	for k, v := range tags {
+		entry.Str(k, v)
+	}
+	for k, v := range fields {
+		switch tv := v.(type) {
+		case int:
+			entry.Int(k, tv)
+		case int64:
+			entry.Int(k, int(tv))
+		case float64:
+			entry.Float(k, tv)
+		case bool:
+			entry.Bool(k, tv)
+		case string:
+			entry.Str(k, tv)
+		default:
+			entry.Str(k, fmt.Sprintf("%v", tv))
+		}
 	}
+	return entry
 }
@@ -20,13 +20,22 @@ package reporting
 
 import (
 	"encoding/json"
+	"maps"
+	"slices"
 	"time"
 
 	"github.com/czcorpus/hltscl"
 	"github.com/czcorpus/mariadb-tscl/db"
 )
 
-const MariaDBTSCLStatusMonitoringTable = "mariadb_tscl_status_monitoring"
+const (
+	MariaDBTSCLStatusMonitoringTable = "mariadb_tscl_status_monitoring"
+	MariaDBTSCLSelfTable             = "mariadb_tscl_self"
+
+	// TimeColumnName is the name of the time column used
+	// as the hypertable partitioning column in all the tables
+	TimeColumnName = "time"
+)
 
 // -----
 
@@ -42,19 +51,90 @@ type BackendActionType string
 type ConnectionsStatus struct {
 	Created  time.Time `json:"created"`
 	Instance string    `json:"instance"`
+
+	// IntervalMs is the actual time elapsed since the previous
+	// sample the differences are related to
+	IntervalMs int `json:"intervalMs"`
+
 	db.Status
+
+	// Totals contains the current values of the cumulative counters
+	// the differences have been calculated from. It is not stored
+	// and it may be nil.
+	Totals *db.Status `json:"-"`
+
+	// TotalsStart is the time the Totals are counted from (i.e. the
+	// server start). It changes only after a restart of the server.
+	TotalsStart time.Time `json:"-"`
+}
+
+// ToInfluxDB provides tags (the first returned value) and fields
+// (the second returned value) of the status record
+func (status *ConnectionsStatus) ToInfluxDB() (map[string]string, map[string]any) {
+	return map[string]string{
+			"instance": status.Instance,
+		},
+		map[string]any{
+			"interval_ms":                      status.IntervalMs,
+			"threads_connected":                status.ThreadsConnected,
+			"max_used_connections":             status.MaxUsedConnections,
+			"aborted_connects":                 status.AbortedConnects,
+			"com_select":                       status.ComSelect,
+			"com_insert":                       status.ComInsert,
+			"com_update":                       status.ComUpdate,
+			"com_delete":                       status.ComDelete,
+			"slow_queries":                     status.SlowQueries,
+			"innodb_buffer_pool_reads":         status.InnodbBufferPoolReads,
+			"innodb_buffer_pool_read_requests": status.InnodbBufferPoolReadRequests,
+			"innodb_row_lock_time":             status.InnodbRowLockTime,
+			"handler_read_first":               status.HandlerReadFirst,
+			"handler_read_key":                 status.HandlerReadKey,
+			"handler_read_next":                status.HandlerReadNext,
+			"handler_read_rnd":                 status.HandlerReadRnd,
+			"handler_read_rnd_next":            status.HandlerReadRndNext,
+			"bytes_sent":                       status.BytesSent,
+			"bytes_received":                   status.BytesReceived,
+		}
+}
+
+// CounterFields provides all the fields except for gauges
+// and the interval
+func (status *ConnectionsStatus) CounterFields() []string {
+	_, fields := status.ToInfluxDB()
+	ans := make([]string, 0, len(fields))
+	for _, k := range slices.Sorted(maps.Keys(fields)) {
+		if !statusGaugeColumns[k] && k != "interval_ms" {
+			ans = append(ans, k)
+		}
+	}
+	return ans
+}
+
+// CumulativeValues provides the counter fields of Totals along
+// with the time they are counted from
+func (status *ConnectionsStatus) CumulativeValues() (time.Time, map[string]any, bool) {
+	if status.Totals == nil || status.TotalsStart.IsZero() {
+		return time.Time{}, nil, false
+	}
+	_, fields := (&ConnectionsStatus{Status: *status.Totals}).ToInfluxDB()
+	ans := make(map[string]any)
+	for _, k := range status.CounterFields() {
+		ans[k] = fields[k]
+	}
+	return status.TotalsStart, ans, true
 }
 
 func (status *ConnectionsStatus) ToTimescaleDB(tableWriter *hltscl.TableWriter) *hltscl.Entry {
 	return tableWriter.NewEntry(status.Created).
 		Str("instance", status.Instance).
+		Int("interval_ms", status.IntervalMs).
 		Int("threads_connected", status.ThreadsConnected).
 		Int("max_used_connections", status.MaxUsedConnections).
 		Int("aborted_connects", status.AbortedConnects).
 		Int("com_select", status.ComSelect).
 		Int("com_insert", status.ComInsert).
 		Int("com_update", status.ComUpdate).
-		Int("com_delete", status.ComUpdate).
+		Int("com_delete", status.ComDelete).
 		Int("slow_queries", status.SlowQueries).
 		Int("innodb_buffer_pool_reads", status.InnodbBufferPoolReads).
 		Int("innodb_buffer_pool_read_requests", status.InnodbBufferPoolReadRequests).
@@ -79,3 +159,102 @@ func (status *ConnectionsStatus) GetTableName() string {
 func (report *ConnectionsStatus) MarshalJSON() ([]byte, error) {
 	return json.Marshal(*report)
 }
+
+// ----
+
+// CollectorSelfStatus contains performance metrics of a single
+// collector (aggregated over the self-monitoring interval)
+type CollectorSelfStatus struct {
+	Created           time.Time `json:"created"`
+	Instance          string    `json:"instance"`
+	Collector         string    `json:"collector"`
+	Collections       int       `json:"collections"`
+	CollectionTimeAvg float64   `json:"collectionTimeAvgMs"`
+	CollectionTimeMax float64   `json:"collectionTimeMaxMs"`
+	QueryErrors       int       `json:"queryErrors"`
+	SkippedTicks      int       `json:"skippedTicks"`
+}
+
+func (status *CollectorSelfStatus) ToInfluxDB() (map[string]string, map[string]any) {
+	return map[string]string{
+			"instance":  status.Instance,
+			"collector": status.Collector,
+		},
+		map[string]any{
+			"collections":            status.Collections,
+			"collection_time_avg_ms": status.CollectionTimeAvg,
+			"collection_time_max_ms": status.CollectionTimeMax,
+			"query_errors":           status.QueryErrors,
+			"skipped_ticks":          status.SkippedTicks,
+		}
+}
+
+func (status *CollectorSelfStatus) CounterFields() []string {
+	return []string{"collections", "query_errors", "skipped_ticks"}
+}
+
+func (status *CollectorSelfStatus) ToTimescaleDB(tableWriter *hltscl.TableWriter) *hltscl.Entry {
+	tags, fields := status.ToInfluxDB()
+	return newEntry(tableWriter, status.Created, tags, fields)
+}
+
+func (status *CollectorSelfStatus) GetTime() time.Time {
+	return status.Created
+}
+
+func (status *CollectorSelfStatus) GetTableName() string {
+	return MariaDBTSCLSelfTable
+}
+
+func (status *CollectorSelfStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(*status)
+}
+
+// ----
+
+// ProcessSelfStatus contains metrics of the whole MariaDB-TSCL
+// process including the reporting pipeline. Counters (write errors,
+// dropped entries) are differences since the previous record.
+type ProcessSelfStatus struct {
+	Created        time.Time `json:"created"`
+	Instance       string    `json:"instance"`
+	WriteErrors    int       `json:"writeErrors"`
+	QueueDepth     int       `json:"queueDepth"`
+	DroppedEntries int       `json:"droppedEntries"`
+	RSSBytes       int       `json:"rssBytes"`
+	Goroutines     int       `json:"goroutines"`
+}
+
+func (status *ProcessSelfStatus) ToInfluxDB() (map[string]string, map[string]any) {
+	return map[string]string{
+			"instance": status.Instance,
+		},
+		map[string]any{
+			"write_errors":    status.WriteErrors,
+			"queue_depth":     status.QueueDepth,
+			"dropped_entries": status.DroppedEntries,
+			"rss_bytes":       status.RSSBytes,
+			"goroutines":      status.Goroutines,
+		}
+}
+
+func (status *ProcessSelfStatus) CounterFields() []string {
+	return []string{"write_errors", "dropped_entries"}
+}
+
+func (status *ProcessSelfStatus) ToTimescaleDB(tableWriter *hltscl.TableWriter) *hltscl.Entry {
+	tags, fields := status.ToInfluxDB()
+	return newEntry(tableWriter, status.Created, tags, fields)
+}
+
+func (status *ProcessSelfStatus) GetTime() time.Time {
+	return status.Created
+}
+
+func (status *ProcessSelfStatus) GetTableName() string {
+	return MariaDBTSCLSelfTable
+}
+
+func (status *ProcessSelfStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(*status)
+}
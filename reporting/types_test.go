@@ -0,0 +1,89 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package reporting_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/czcorpus/mariadb-tscl/reporting/reportingtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// columnName converts a JSON key of a status field
+// to the respective column name
+func columnName(jsonKey string) string {
+	var ans strings.Builder
+	for _, r := range jsonKey {
+		if unicode.IsUpper(r) {
+			ans.WriteRune('_')
+		}
+		ans.WriteRune(unicode.ToLower(r))
+	}
+	return ans.String()
+}
+
+func TestConnectionsStatusFieldMapping(t *testing.T) {
+	// each field gets a unique value so any mix-up
+	// of fields and columns is detected
+	var status db.Status
+	expected := make(map[string]any)
+	sv := reflect.ValueOf(&status).Elem()
+	for i := 0; i < sv.NumField(); i++ {
+		sv.Field(i).SetInt(int64(1000 + i))
+		if key := sv.Type().Field(i).Tag.Get("json"); key != "-" {
+			expected[columnName(key)] = 1000 + i
+		}
+	}
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	expected["time"] = created
+	expected["instance"] = "test"
+	expected["interval_ms"] = 10000
+
+	writer := reportingtest.NewWriter()
+	writer.AddTableWriter(reporting.MariaDBTSCLStatusMonitoringTable)
+	writer.Write(&reporting.ConnectionsStatus{
+		Created:    created,
+		Instance:   "test",
+		IntervalMs: 10000,
+		Status:     status,
+	})
+	entries := writer.Entries()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, reporting.MariaDBTSCLStatusMonitoringTable, entries[0].Table)
+	values := entries[0].Values()
+	if tm, ok := values["time"].(time.Time); ok {
+		values["time"] = tm.UTC()
+	}
+	assert.Equal(t, expected, values)
+}
+
+func TestTableColumnsMatchExpected(t *testing.T) {
+	cols := reporting.TableColumns(&reporting.ConnectionsStatus{})
+	assert.Contains(t, cols, reporting.TimeColumnName)
+	assert.Contains(t, cols, "com_delete")
+	assert.NotContains(t, cols, "uptime")
+	// time, instance and interval_ms are added, uptime is not reported
+	assert.Len(t, cols, reflect.TypeOf(db.Status{}).NumField()+3-1)
+}
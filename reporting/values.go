@@ -0,0 +1,40 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package reporting
+
+// NumericValue converts a field value (as provided by ToInfluxDB)
+// to a number. Booleans are converted to 0 and 1, other
+// non-numeric values are not convertible.
+func NumericValue(v any) (float64, bool) {
+	switch tv := v.(type) {
+	case int:
+		return float64(tv), true
+	case int64:
+		return float64(tv), true
+	case float64:
+		return tv, true
+	case bool:
+		if tv {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
@@ -1,6 +1,7 @@
 create table mariadb_tscl_status_monitoring (
   "time" timestamp with time zone NOT NULL,
   instance TEXT,
+  interval_ms int,
   threads_connected int,
   max_used_connections int,
   aborted_connects int,
@@ -18,6 +19,49 @@ create table mariadb_tscl_status_monitoring (
   handler_read_rnd int,
   handler_read_rnd_next int,
   bytes_sent int,
-  bytes_received int
+  bytes_received int,
+  labels jsonb
 );
 select create_hypertable('mariadb_tscl_status_monitoring', 'time');
+-- upgrading an existing installation:
+-- alter table mariadb_tscl_status_monitoring add column interval_ms int;
+-- alter table mariadb_tscl_status_monitoring add column labels jsonb; -- if `labels` are configured
+
+create table mariadb_tscl_self (
+  "time" timestamp with time zone NOT NULL,
+  instance TEXT,
+  collector TEXT,
+  collections int,
+  collection_time_avg_ms double precision,
+  collection_time_max_ms double precision,
+  query_errors int,
+  skipped_ticks int,
+  write_errors int,
+  queue_depth int,
+  dropped_entries int,
+  rss_bytes bigint,
+  goroutines int,
+  labels jsonb
+);
+select create_hypertable('mariadb_tscl_self', 'time');
+-- upgrading an existing installation:
+-- alter table mariadb_tscl_self add column labels jsonb; -- if `labels` are configured
+
+-- narrow layout (reporting.layout = "narrow"); records of all the tables
+-- above are stored as rows of a single table
+create table mariadb_tscl_metrics (
+  "time" timestamp with time zone NOT NULL,
+  instance TEXT,
+  metric TEXT NOT NULL,
+  value double precision,
+  labels jsonb
+);
+select create_hypertable('mariadb_tscl_metrics', 'time');
+create index on mariadb_tscl_metrics (instance, metric, "time" desc);
+
+create table mariadb_tscl_metric_dict (
+  metric TEXT PRIMARY KEY,
+  source_table TEXT NOT NULL,
+  field TEXT NOT NULL,
+  kind TEXT NOT NULL
+);
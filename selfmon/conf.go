@@ -0,0 +1,47 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package selfmon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/general"
+)
+
+const dfltInterval = general.Duration(time.Minute)
+
+// Conf configures reporting of MariaDB-TSCL's own metrics
+// (written to the mariadb_tscl_self table)
+type Conf struct {
+	Interval general.Duration `json:"interval"`
+}
+
+func (conf *Conf) ValidateAndDefaults() error {
+	if conf == nil {
+		return nil
+	}
+	if conf.Interval == 0 {
+		conf.Interval = dfltInterval
+
+	} else if conf.Interval < 0 {
+		return fmt.Errorf("invalid selfMonitoring.interval %s", conf.Interval)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package selfmon
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/reporting"
+)
+
+// CollectorStats accumulates performance metrics of a collector
+// between two self-monitoring reports. It is safe for concurrent use.
+type CollectorStats struct {
+	mu            sync.Mutex
+	collections   int
+	totalDuration time.Duration
+	maxDuration   time.Duration
+	queryErrors   int
+	skippedTicks  int
+}
+
+// ObserveCollection registers a single collecting round
+func (cs *CollectorStats) ObserveCollection(duration time.Duration, err error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.collections++
+	cs.totalDuration += duration
+	cs.maxDuration = max(cs.maxDuration, duration)
+	if err != nil {
+		cs.queryErrors++
+	}
+}
+
+// AddSkippedTicks registers ticks for which no collecting
+// has been performed (e.g. due to a slow previous round
+// or due to backing off from an unavailable server)
+func (cs *CollectorStats) AddSkippedTicks(n int) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.skippedTicks += n
+}
+
+// flush returns accumulated values as a record and resets them
+func (cs *CollectorStats) flush(now time.Time, instance, collector string) *reporting.CollectorSelfStatus {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	ans := &reporting.CollectorSelfStatus{
+		Created:           now,
+		Instance:          instance,
+		Collector:         collector,
+		Collections:       cs.collections,
+		CollectionTimeMax: float64(cs.maxDuration.Microseconds()) / 1000,
+		QueryErrors:       cs.queryErrors,
+		SkippedTicks:      cs.skippedTicks,
+	}
+	if cs.collections > 0 {
+		ans.CollectionTimeAvg = float64(cs.totalDuration.Microseconds()) / 1000 / float64(cs.collections)
+	}
+	cs.collections = 0
+	cs.totalDuration = 0
+	cs.maxDuration = 0
+	cs.queryErrors = 0
+	cs.skippedTicks = 0
+	return ans
+}
+
+// ----
+
+// Monitor collects metrics describing MariaDB-TSCL itself
+type Monitor struct {
+	mu         sync.Mutex
+	collectors map[string]*CollectorStats
+
+	// prevWriteErrors and prevDropped are cumulative values
+	// (per table) from the previous report
+	prevWriteErrors map[string]int64
+	prevDropped     map[string]int64
+}
+
+// Collector provides stats of a collector with the specified name
+// (a new instance is created if needed)
+func (m *Monitor) Collector(name string) *CollectorStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cs, ok := m.collectors[name]
+	if !ok {
+		cs = &CollectorStats{}
+		m.collectors[name] = cs
+	}
+	return cs
+}
+
+// Report creates records with metrics accumulated since the previous
+// report. The queueStats are taken from the reporting writer.
+func (m *Monitor) Report(instance string, queueStats []reporting.QueueStats) []reporting.Timescalable {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	ans := make([]reporting.Timescalable, 0, len(m.collectors)+1)
+	for name, cs := range m.collectors {
+		ans = append(ans, cs.flush(now, instance, name))
+	}
+	proc := &reporting.ProcessSelfStatus{
+		Created:    now,
+		Instance:   instance,
+		RSSBytes:   int(processRSS()),
+		Goroutines: runtime.NumGoroutine(),
+	}
+	for _, qs := range queueStats {
+		proc.QueueDepth += qs.Depth
+		proc.WriteErrors += int(qs.WriteErrors - m.prevWriteErrors[qs.Table])
+		proc.DroppedEntries += int(qs.Dropped - m.prevDropped[qs.Table])
+		m.prevWriteErrors[qs.Table] = qs.WriteErrors
+		m.prevDropped[qs.Table] = qs.Dropped
+	}
+	return append(ans, proc)
+}
+
+// ResetQueueBaseline should be called when the reporting writer
+// is replaced (its counters start from zero)
+func (m *Monitor) ResetQueueBaseline() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prevWriteErrors = make(map[string]int64)
+	m.prevDropped = make(map[string]int64)
+}
+
+func NewMonitor() *Monitor {
+	return &Monitor{
+		collectors:      make(map[string]*CollectorStats),
+		prevWriteErrors: make(map[string]int64),
+		prevDropped:     make(map[string]int64),
+	}
+}
+
+// processRSS provides resident set size of the process. On systems
+// without procfs, memory obtained from the OS by the Go runtime
+// is used as an approximation.
+func processRSS() int64 {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) > 1 {
+			pages, err := strconv.ParseInt(fields[1], 10, 64)
+			if err == nil {
+				return pages * int64(os.Getpagesize())
+			}
+		}
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return int64(mem.Sys)
+}
@@ -0,0 +1,241 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/czcorpus/mariadb-tscl/collector"
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/rs/zerolog/log"
+)
+
+// errNotRecordable is returned for collector queries which cannot
+// be replayed from snapshots (those contain plain queries only)
+var errNotRecordable = errors.New("queries with arguments and prepared statements cannot be recorded")
+
+// Recorder opens database connections which record results of all
+// the queries run by collectors (i.e. queries with a context created
+// via collector.WithCollection). Queries are passed to the database
+// unchanged. Collector queries with arguments and prepared statements
+// are refused as they could not be replayed.
+type Recorder struct {
+	writer *Writer
+}
+
+// OpenDB opens a recording connection to the database
+func (r *Recorder) OpenDB(conf *db.Conf) (*sql.DB, error) {
+	connector, err := db.NewConnector(conf)
+	if err != nil {
+		return nil, err
+	}
+	ans := sql.OpenDB(&recordingConnector{inner: connector, recorder: r})
+	db.SetupPool(ans, conf)
+	return ans, nil
+}
+
+func (r *Recorder) record(ctx context.Context, snap Snapshot) {
+	coll, ok := collector.CollectionFromContext(ctx)
+	if !ok {
+		return
+	}
+	snap.Time = coll.Time
+	snap.Collector = coll.Collector
+	if err := r.writer.Write(snap); err != nil {
+		log.Error().Err(err).Msg("failed to record query result")
+	}
+}
+
+func NewRecorder(writer *Writer) *Recorder {
+	return &Recorder{writer: writer}
+}
+
+// ----
+
+type recordingConnector struct {
+	inner    driver.Connector
+	recorder *Recorder
+}
+
+func (c *recordingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.inner.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingConn{Conn: conn, recorder: c.recorder}, nil
+}
+
+func (c *recordingConnector) Driver() driver.Driver {
+	return c.inner.Driver()
+}
+
+// ----
+
+// recordingConn wraps a driver connection. Besides recording query
+// results, it passes through optional driver interfaces the database/sql
+// package relies on (pinging, session resetting, validation).
+type recordingConn struct {
+	driver.Conn
+	recorder *Recorder
+}
+
+func (c *recordingConn) QueryContext(
+	ctx context.Context,
+	query string,
+	args []driver.NamedValue,
+) (driver.Rows, error) {
+	_, recorded := collector.CollectionFromContext(ctx)
+	if recorded && len(args) > 0 {
+		return nil, fmt.Errorf("failed to record query %s: %w", query, errNotRecordable)
+	}
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if errors.Is(err, driver.ErrSkip) || !recorded {
+		return rows, err
+	}
+	if err != nil {
+		c.recorder.record(ctx, Snapshot{Query: query, Error: err.Error()})
+		return nil, err
+	}
+	buffered, err := bufferRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	snap := Snapshot{Query: query, Columns: buffered.columns}
+	for _, row := range buffered.rows {
+		srow := make([]*string, len(row))
+		for i, v := range row {
+			if v != nil {
+				sv := valueToString(v)
+				srow[i] = &sv
+			}
+		}
+		snap.Rows = append(snap.Rows, srow)
+	}
+	c.recorder.record(ctx, snap)
+	return buffered, nil
+}
+
+// PrepareContext prepares statements for queries run outside
+// collectors only as prepared statements are not recorded
+func (c *recordingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if _, ok := collector.CollectionFromContext(ctx); ok {
+		return nil, fmt.Errorf("failed to record query %s: %w", query, errNotRecordable)
+	}
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *recordingConn) ExecContext(
+	ctx context.Context,
+	query string,
+	args []driver.NamedValue,
+) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (c *recordingConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *recordingConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *recordingConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// ----
+
+// bufferedRows contains a fully read result
+type bufferedRows struct {
+	columns []string
+	rows    [][]driver.Value
+	pos     int
+}
+
+func (r *bufferedRows) Columns() []string {
+	return r.columns
+}
+
+func (r *bufferedRows) Close() error {
+	return nil
+}
+
+func (r *bufferedRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.pos])
+	r.pos++
+	return nil
+}
+
+func bufferRows(rows driver.Rows) (*bufferedRows, error) {
+	defer rows.Close()
+	ans := &bufferedRows{columns: rows.Columns()}
+	for {
+		dest := make([]driver.Value, len(ans.columns))
+		err := rows.Next(dest)
+		if err == io.EOF {
+			return ans, nil
+
+		} else if err != nil {
+			return nil, err
+		}
+		for i, v := range dest {
+			// drivers may reuse their buffers
+			if b, ok := v.([]byte); ok {
+				dest[i] = append([]byte{}, b...)
+			}
+		}
+		ans.rows = append(ans.rows, dest)
+	}
+}
+
+func valueToString(v driver.Value) string {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,457 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package replayserver provides an in-process stand-in for MariaDB
+// which speaks (a small subset of) the MySQL client/server protocol.
+// Query results are scripted in advance (typically out of recorded
+// snapshots) so collectors can be fed with recorded data without
+// a real database server.
+package replayserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/snapshot"
+)
+
+const (
+	serverVersion = "10.11.99-MariaDB-replay"
+
+	comQuit  = 0x01
+	comQuery = 0x03
+	comPing  = 0x0e
+
+	capLongPassword    = 0x00000001
+	capFoundRows       = 0x00000002
+	capLongFlag        = 0x00000004
+	capConnectWithDB   = 0x00000008
+	capProtocol41      = 0x00000200
+	capTransactions    = 0x00002000
+	capSecureConn      = 0x00008000
+	capPluginAuth      = 0x00080000
+	serverCapabilities = capLongPassword | capFoundRows | capLongFlag | capConnectWithDB |
+		capProtocol41 | capTransactions | capSecureConn | capPluginAuth
+
+	statusAutocommit = 0x0002
+
+	typeVarString = 0xfd
+	charsetUTF8   = 33
+
+	errUnknownQuery = 1064
+)
+
+// Result is a scripted result of a query. If Err is set, the server
+// responds with an error packet. A result without columns is sent
+// as a plain OK response.
+type Result struct {
+	Columns []string
+	Rows    [][]any
+	Err     error
+}
+
+// StatusResult creates a result of SHOW GLOBAL STATUS (or SHOW GLOBAL
+// VARIABLES) out of variable names and their values
+func StatusResult(values map[string]any) *Result {
+	ans := &Result{Columns: []string{"Variable_name", "Value"}}
+	for k, v := range values {
+		ans.Rows = append(ans.Rows, []any{k, v})
+	}
+	return ans
+}
+
+// script is a sequence of results for queries with the same prefix
+// (or for a single query in case of exact scripts)
+type script struct {
+	prefix  string
+	exact   bool
+	results []*Result
+	calls   int
+}
+
+func (sc *script) matches(normalized string) bool {
+	if sc.exact {
+		return normalized == sc.prefix
+	}
+	return strings.HasPrefix(normalized, sc.prefix)
+}
+
+// next provides the next scripted result. The last
+// result is repeated once the script is exhausted.
+func (sc *script) next() *Result {
+	ans := sc.results[min(sc.calls, len(sc.results)-1)]
+	sc.calls++
+	return ans
+}
+
+// Server is a fake MariaDB server listening on a local TCP port.
+// Any credentials are accepted.
+type Server struct {
+	listener net.Listener
+	mu       sync.Mutex
+	scripts  []*script
+	queries  []string
+	conns    map[net.Conn]bool
+	connID   uint32
+	wg       sync.WaitGroup
+}
+
+// Expect registers results for queries starting with `prefix`
+// (compared case-insensitively). Consecutive matching queries
+// get consecutive results; the last one is repeated. Scripts
+// registered later take precedence.
+func (s *Server) Expect(prefix string, results ...*Result) {
+	if len(results) == 0 {
+		panic("replayserver: no results for " + prefix)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts = append(s.scripts, &script{prefix: strings.ToLower(prefix), results: results})
+}
+
+// ExpectExact registers results for the query (compared
+// case-insensitively, ignoring surrounding whitespace). Exact
+// scripts take precedence over the ones registered via Expect.
+func (s *Server) ExpectExact(query string, results ...*Result) {
+	if len(results) == 0 {
+		panic("replayserver: no results for " + query)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts = append(s.scripts, &script{
+		prefix:  strings.ToLower(strings.TrimSpace(query)),
+		exact:   true,
+		results: results,
+	})
+}
+
+// Queries provides all the queries received so far
+func (s *Server) Queries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.queries...)
+}
+
+// Addr provides host:port the server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Conf provides a configuration for connecting to the server
+func (s *Server) Conf() *db.Conf {
+	return &db.Conf{
+		Name:     "replay",
+		Host:     s.Addr(),
+		User:     "replay",
+		Password: "replay",
+	}
+}
+
+// Close stops the server and closes all the client connections
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) resultFor(query string) *Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = append(s.queries, query)
+	normalized := strings.ToLower(strings.TrimSpace(query))
+	for _, exact := range []bool{true, false} {
+		for i := len(s.scripts) - 1; i >= 0; i-- {
+			if s.scripts[i].exact == exact && s.scripts[i].matches(normalized) {
+				return s.scripts[i].next()
+			}
+		}
+	}
+	if strings.HasPrefix(normalized, "set ") {
+		return &Result{}
+	}
+	return &Result{Err: fmt.Errorf("replayserver: unexpected query %s", query)}
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.connID++
+		id := s.connID
+		s.conns[conn] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			s.handleConn(newPacketConn(conn), id)
+		}()
+	}
+}
+
+func (s *Server) handleConn(pc *packetConn, id uint32) {
+	if err := pc.writePacket(handshakePacket(id)); err != nil {
+		return
+	}
+	if _, err := pc.readPacket(); err != nil {
+		return
+	}
+	if err := pc.writePacket(okPacket()); err != nil {
+		return
+	}
+	for {
+		pc.seq = 0
+		data, err := pc.readPacket()
+		if err != nil || len(data) == 0 {
+			return
+		}
+		switch data[0] {
+		case comQuit:
+			return
+		case comPing:
+			err = pc.writePacket(okPacket())
+		case comQuery:
+			err = pc.writeResult(s.resultFor(string(data[1:])))
+		default:
+			err = pc.writePacket(errPacket(errUnknownQuery, fmt.Sprintf("replayserver: unsupported command %d", data[0])))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// NewServer starts a new server on a random local port
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start replay server: %w", err)
+	}
+	ans := &Server{
+		listener: listener,
+		conns:    make(map[net.Conn]bool),
+	}
+	ans.wg.Add(1)
+	go ans.serve()
+	return ans, nil
+}
+
+// ----
+
+type packetConn struct {
+	r   *bufio.Reader
+	w   io.Writer
+	seq byte
+}
+
+func (pc *packetConn) readPacket() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(pc.r, header[:]); err != nil {
+		return nil, err
+	}
+	size := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	pc.seq = header[3] + 1
+	data := make([]byte, size)
+	if _, err := io.ReadFull(pc.r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (pc *packetConn) writePacket(data []byte) error {
+	if len(data) >= 1<<24-1 {
+		return errors.New("replayserver: packet too large")
+	}
+	buf := make([]byte, 4, 4+len(data))
+	buf[0] = byte(len(data))
+	buf[1] = byte(len(data) >> 8)
+	buf[2] = byte(len(data) >> 16)
+	buf[3] = pc.seq
+	pc.seq++
+	_, err := pc.w.Write(append(buf, data...))
+	return err
+}
+
+func (pc *packetConn) writeResult(res *Result) error {
+	if res.Err != nil {
+		return pc.writePacket(errPacket(errUnknownQuery, res.Err.Error()))
+	}
+	if len(res.Columns) == 0 {
+		return pc.writePacket(okPacket())
+	}
+	if err := pc.writePacket(appendLenEncInt(nil, uint64(len(res.Columns)))); err != nil {
+		return err
+	}
+	for _, col := range res.Columns {
+		if err := pc.writePacket(columnPacket(col)); err != nil {
+			return err
+		}
+	}
+	if err := pc.writePacket(eofPacket()); err != nil {
+		return err
+	}
+	for _, row := range res.Rows {
+		var data []byte
+		for _, v := range row {
+			if v == nil {
+				data = append(data, 0xfb)
+
+			} else {
+				data = appendLenEncString(data, fmt.Sprint(v))
+			}
+		}
+		if err := pc.writePacket(data); err != nil {
+			return err
+		}
+	}
+	return pc.writePacket(eofPacket())
+}
+
+func newPacketConn(conn net.Conn) *packetConn {
+	return &packetConn{r: bufio.NewReader(conn), w: conn}
+}
+
+func appendLenEncInt(b []byte, v uint64) []byte {
+	switch {
+	case v < 251:
+		return append(b, byte(v))
+	case v < 1<<16:
+		return append(b, 0xfc, byte(v), byte(v>>8))
+	case v < 1<<24:
+		return append(b, 0xfd, byte(v), byte(v>>8), byte(v>>16))
+	default:
+		b = append(b, 0xfe)
+		return binary.LittleEndian.AppendUint64(b, v)
+	}
+}
+
+func appendLenEncString(b []byte, s string) []byte {
+	return append(appendLenEncInt(b, uint64(len(s))), s...)
+}
+
+func handshakePacket(connID uint32) []byte {
+	authData := []byte("replay-scramble-0123")
+	data := []byte{0x0a}
+	data = append(data, serverVersion...)
+	data = append(data, 0)
+	data = binary.LittleEndian.AppendUint32(data, connID)
+	data = append(data, authData[:8]...)
+	data = append(data, 0)
+	data = binary.LittleEndian.AppendUint16(data, uint16(serverCapabilities&0xffff))
+	data = append(data, charsetUTF8)
+	data = binary.LittleEndian.AppendUint16(data, statusAutocommit)
+	data = binary.LittleEndian.AppendUint16(data, uint16(serverCapabilities>>16))
+	data = append(data, byte(len(authData)+1))
+	data = append(data, make([]byte, 10)...)
+	data = append(data, authData[8:]...)
+	data = append(data, 0)
+	data = append(data, "mysql_native_password"...)
+	return append(data, 0)
+}
+
+func okPacket() []byte {
+	data := []byte{0x00, 0x00, 0x00}
+	data = binary.LittleEndian.AppendUint16(data, statusAutocommit)
+	return binary.LittleEndian.AppendUint16(data, 0)
+}
+
+func eofPacket() []byte {
+	data := []byte{0xfe}
+	data = binary.LittleEndian.AppendUint16(data, 0)
+	return binary.LittleEndian.AppendUint16(data, statusAutocommit)
+}
+
+func errPacket(code uint16, msg string) []byte {
+	data := []byte{0xff}
+	data = binary.LittleEndian.AppendUint16(data, code)
+	data = append(data, "#42000"...)
+	return append(data, msg...)
+}
+
+func columnPacket(name string) []byte {
+	var data []byte
+	data = appendLenEncString(data, "def")
+	data = appendLenEncString(data, "")
+	data = appendLenEncString(data, "")
+	data = appendLenEncString(data, "")
+	data = appendLenEncString(data, name)
+	data = appendLenEncString(data, name)
+	data = append(data, 0x0c)
+	data = binary.LittleEndian.AppendUint16(data, charsetUTF8)
+	data = binary.LittleEndian.AppendUint32(data, 1024)
+	data = append(data, typeVarString)
+	data = binary.LittleEndian.AppendUint16(data, 0)
+	return append(data, 0, 0, 0)
+}
+
+// ----
+
+// NewServerFromSnapshots starts a new server answering queries
+// with the recorded results (in the recorded order)
+func NewServerFromSnapshots(snapshots []snapshot.Snapshot) (*Server, error) {
+	server, err := NewServer()
+	if err != nil {
+		return nil, err
+	}
+	queries := make([]string, 0, 10)
+	results := make(map[string][]*Result)
+	for _, snap := range snapshots {
+		res := &Result{Columns: snap.Columns}
+		if snap.Error != "" {
+			res.Err = fmt.Errorf("%s", snap.Error)
+		}
+		for _, row := range snap.Rows {
+			vals := make([]any, len(row))
+			for i, v := range row {
+				if v != nil {
+					vals[i] = *v
+				}
+			}
+			res.Rows = append(res.Rows, vals)
+		}
+		if _, ok := results[snap.Query]; !ok {
+			queries = append(queries, snap.Query)
+		}
+		results[snap.Query] = append(results[snap.Query], res)
+	}
+	for _, q := range queries {
+		server.ExpectExact(q, results[q]...)
+	}
+	return server, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//                Faculty of Arts, Charles University
+//   This file is part of MARIADB-TSCL.
+//
+//  MARIADB-TSCL is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  MARIADB-TSCL is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package snapshot provides recording of raw results of collector
+// queries so they can be later replayed (see monitor.Replay).
+// Recordings are stored as gzip-compressed JSON lines.
+package snapshot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Snapshot is a recorded result of a single query
+type Snapshot struct {
+
+	// Time is the time of the collector run the query belongs to
+	Time      time.Time `json:"time"`
+	Collector string    `json:"collector"`
+	Query     string    `json:"query"`
+	Columns   []string  `json:"columns,omitempty"`
+
+	// Rows contains values as returned by the server
+	// (nil represents NULL)
+	Rows  [][]*string `json:"rows,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+// Writer writes snapshots to a compressed file
+type Writer struct {
+	mu      sync.Mutex
+	file    *os.File
+	gz      *gzip.Writer
+	enc     *json.Encoder
+	written int
+}
+
+func (w *Writer) Write(snap Snapshot) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.enc.Encode(snap); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	w.written++
+	return nil
+}
+
+// Written provides number of snapshots written so far
+func (w *Writer) Written() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written
+}
+
+// Close finishes the compressed stream and closes the file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.gz.Close(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("failed to close snapshot file: %w", err)
+	}
+	return w.file.Close()
+}
+
+// Create creates (or truncates) a snapshot file
+func Create(path string) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	gz := gzip.NewWriter(file)
+	return &Writer{file: file, gz: gz, enc: json.NewEncoder(gz)}, nil
+}
+
+// ReadFile loads all the snapshots stored in the file
+func ReadFile(path string) ([]Snapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot file: %w", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot file %s: %w", path, err)
+	}
+	defer gz.Close()
+	dec := json.NewDecoder(gz)
+	ans := make([]Snapshot, 0, 1000)
+	for dec.More() {
+		var snap Snapshot
+		if err := dec.Decode(&snap); err != nil {
+			return nil, fmt.Errorf("failed to read snapshot file %s: %w", path, err)
+		}
+		ans = append(ans, snap)
+	}
+	return ans, nil
+}
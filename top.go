@@ -0,0 +1,388 @@
+// Copyright 2024 Martin Zimandl <martin.zimandl@gmail.com>
+// Copyright 2024 Institute of the Czech National Corpus,
+//
+//	              Faculty of Arts, Charles University
+//	 This file is part of MARIADB-TSCL.
+//
+//	MARIADB-TSCL is free software: you can redistribute it and/or modify
+//	it under the terms of the GNU General Public License as published by
+//	the Free Software Foundation, either version 3 of the License, or
+//	(at your option) any later version.
+//
+//	MARIADB-TSCL is distributed in the hope that it will be useful,
+//	but WITHOUT ANY WARRANTY; without even the implied warranty of
+//	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//	GNU General Public License for more details.
+//
+//	You should have received a copy of the GNU General Public License
+//	along with MARIADB-TSCL.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/czcorpus/mariadb-tscl/cnf"
+	"github.com/czcorpus/mariadb-tscl/collector"
+	"github.com/czcorpus/mariadb-tscl/db"
+	"github.com/czcorpus/mariadb-tscl/reporting"
+	"github.com/czcorpus/mariadb-tscl/reporting/sqlite"
+)
+
+const (
+	ansiClearScreen = "\033[H\033[2J"
+	ansiBold        = "\033[1m"
+	ansiReset       = "\033[0m"
+
+	topMaxQueryInfoLength = 80
+)
+
+// topMaxRefreshTimeout limits the time a single refresh of an instance
+// may take (the refresh interval applies in case it is shorter) so
+// a stalled server cannot freeze the whole view
+const topMaxRefreshTimeout = 5 * time.Second
+
+// topHistoryFields are status counters summarized
+// from the history stored by the SQLite sink
+var topHistoryFields = []string{
+	"com_select", "com_insert", "com_update", "com_delete", "slow_queries",
+}
+
+// topTarget represents a single monitored instance
+// in the `top` view
+type topTarget struct {
+	conf       *cnf.Conf
+	conn       *sql.DB
+	collectors []collector.Collector
+	prevTime   time.Time
+
+	// history is the database of the SQLite sink (if configured)
+	history    *sqlite.Store
+	historyErr error
+}
+
+// topHistory summarizes status records stored
+// by the SQLite sink over a longer period
+type topHistory struct {
+	period     time.Duration
+	numRecords int
+
+	// avgRates contains average per-second rates of topHistoryFields
+	avgRates map[string]float64
+
+	// peakRates contains the highest per-second rates of
+	// topHistoryFields within a single record (downsampled
+	// records provide rates averaged over their buckets)
+	peakRates map[string]float64
+
+	maxConnections int
+}
+
+type topSnapshot struct {
+	name       string
+	elapsed    time.Duration
+	records    []reporting.Timescalable
+	processes  []db.Process
+	history    *topHistory
+	err        error
+	processErr error
+	historyErr error
+}
+
+// rate provides a per-second rate of a field value. The second
+// returned value is false for non-numeric values.
+func (snap *topSnapshot) rate(v any) (float64, bool) {
+	num, ok := reporting.NumericValue(v)
+	if !ok {
+		return 0, false
+	}
+	if snap.elapsed <= 0 {
+		return 0, true
+	}
+	return num / snap.elapsed.Seconds(), true
+}
+
+func (snap *topSnapshot) formatRate(v any) string {
+	if rate, ok := snap.rate(v); ok {
+		return fmt.Sprintf("%.1f", rate)
+	}
+	return "-"
+}
+
+// bufferPoolHitRatio provides a percentage of InnoDB buffer pool
+// read requests served without reading from the disk. The second
+// returned value is false if there were no requests in the interval.
+func bufferPoolHitRatio(delta *db.Status) (float64, bool) {
+	if delta.InnodbBufferPoolReadRequests <= 0 {
+		return 0, false
+	}
+	return 100 * (1 - float64(delta.InnodbBufferPoolReads)/
+		float64(delta.InnodbBufferPoolReadRequests)), true
+}
+
+// summarizeHistory computes rates of topHistoryFields
+// from the stored status records
+func summarizeHistory(rows []sqlite.Row, period time.Duration) *topHistory {
+	ans := &topHistory{
+		period:     period,
+		numRecords: len(rows),
+		avgRates:   make(map[string]float64),
+		peakRates:  make(map[string]float64),
+	}
+	var totalMs float64
+	sums := make(map[string]float64)
+	for _, row := range rows {
+		if threads, ok := reporting.NumericValue(row.Values["threads_connected"]); ok {
+			ans.maxConnections = max(ans.maxConnections, int(threads))
+		}
+		intervalMs, ok := reporting.NumericValue(row.Values["interval_ms"])
+		if !ok || intervalMs <= 0 {
+			continue
+		}
+		totalMs += intervalMs
+		for _, field := range topHistoryFields {
+			if v, ok := reporting.NumericValue(row.Values[field]); ok {
+				sums[field] += v
+				ans.peakRates[field] = max(ans.peakRates[field], 1000*v/intervalMs)
+			}
+		}
+	}
+	if totalMs > 0 {
+		for field, sum := range sums {
+			ans.avgRates[field] = 1000 * sum / totalMs
+		}
+	}
+	return ans
+}
+
+func (target *topTarget) name() string {
+	if target.conf.InstanceName != "" {
+		return target.conf.InstanceName
+	}
+	return target.conf.DB.Address()
+}
+
+// collect runs all the configured collectors (i.e. the same ones
+// the daemon runs) and obtains the longest running processes
+// and (if available) the history of the last historyPeriod.
+// The first call usually provides no records as collectors
+// need a baseline first.
+func (target *topTarget) collect(
+	ctx context.Context,
+	numProcesses int,
+	historyPeriod time.Duration,
+) *topSnapshot {
+	ans := &topSnapshot{name: target.name()}
+	for _, coll := range target.collectors {
+		records, err := coll.Collect(ctx)
+		if err != nil {
+			ans.err = fmt.Errorf("collector %s: %w", coll.Name(), err)
+			return ans
+		}
+		ans.records = append(ans.records, records...)
+	}
+	now := time.Now()
+	if !target.prevTime.IsZero() {
+		ans.elapsed = now.Sub(target.prevTime)
+	}
+	target.prevTime = now
+	ans.processes, ans.processErr = db.GetLongestProcesses(ctx, target.conn, numProcesses)
+	if target.historyErr != nil {
+		ans.historyErr = target.historyErr
+
+	} else if target.history != nil {
+		rows, err := target.history.Query(
+			ctx,
+			reporting.MariaDBTSCLStatusMonitoringTable,
+			now.Add(-historyPeriod),
+			now,
+			map[string]string{"instance": target.conf.InstanceName},
+		)
+		if err != nil {
+			ans.historyErr = err
+
+		} else {
+			ans.history = summarizeHistory(rows, historyPeriod)
+		}
+	}
+	return ans
+}
+
+// renderTopFields writes per-second rates of the fields
+// in two columns
+func renderTopFields(w io.Writer, snap *topSnapshot, fields map[string]any) {
+	keys := slices.Sorted(maps.Keys(fields))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	half := (len(keys) + 1) / 2
+	for i := 0; i < half; i++ {
+		fmt.Fprintf(tw, "%s/s\t%s", keys[i], snap.formatRate(fields[keys[i]]))
+		if i+half < len(keys) {
+			fmt.Fprintf(tw, "\t\t%s/s\t%s", keys[i+half], snap.formatRate(fields[keys[i+half]]))
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+}
+
+func renderTopStatus(w io.Writer, snap *topSnapshot, rec *reporting.ConnectionsStatus) {
+	fmt.Fprintf(
+		w,
+		"connections: %d (max. used %d)",
+		rec.ThreadsConnected, rec.MaxUsedConnections,
+	)
+	if ratio, ok := bufferPoolHitRatio(&rec.Status); ok {
+		fmt.Fprintf(w, "    buffer pool hit ratio: %.2f %%\n\n", ratio)
+
+	} else {
+		fmt.Fprint(w, "    buffer pool hit ratio: -\n\n")
+	}
+	_, fields := rec.ToInfluxDB()
+	delete(fields, "interval_ms")
+	delete(fields, "threads_connected")
+	delete(fields, "max_used_connections")
+	renderTopFields(w, snap, fields)
+}
+
+func renderTopHistory(w io.Writer, hist *topHistory) {
+	fmt.Fprintf(
+		w,
+		"history of the last %s (%d records): max. connections %d\n",
+		hist.period, hist.numRecords, hist.maxConnections,
+	)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, field := range topHistoryFields {
+		fmt.Fprintf(
+			tw, "%s/s\tavg. %.1f\tpeak %.1f\n",
+			field, hist.avgRates[field], hist.peakRates[field],
+		)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+}
+
+func renderTopSnapshot(w io.Writer, snap *topSnapshot) {
+	fmt.Fprintf(w, "%s== %s ==%s\n", ansiBold, snap.name, ansiReset)
+	if snap.err != nil {
+		fmt.Fprintf(w, "error: %s\n\n", snap.err)
+		return
+	}
+	if len(snap.records) == 0 {
+		fmt.Fprint(w, "waiting for the next sample...\n\n")
+		return
+	}
+	for _, rec := range snap.records {
+		switch rec := rec.(type) {
+		case *reporting.ConnectionsStatus:
+			renderTopStatus(w, snap, rec)
+		default:
+			fmt.Fprintf(w, "%s:\n", rec.GetTableName())
+			_, fields := rec.ToInfluxDB()
+			renderTopFields(w, snap, fields)
+		}
+	}
+
+	if snap.historyErr != nil {
+		fmt.Fprintf(w, "history error: %s\n\n", snap.historyErr)
+
+	} else if snap.history != nil {
+		renderTopHistory(w, snap.history)
+	}
+
+	if snap.processErr != nil {
+		fmt.Fprintf(w, "processlist error: %s\n\n", snap.processErr)
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSER\tDB\tCOMMAND\tTIME\tSTATE\tINFO")
+	for _, proc := range snap.processes {
+		info := strings.Join(strings.Fields(proc.Info), " ")
+		if runes := []rune(info); len(runes) > topMaxQueryInfoLength {
+			info = string(runes[:topMaxQueryInfoLength]) + "..."
+		}
+		fmt.Fprintf(
+			tw, "%d\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			proc.ID, proc.User, proc.DB, proc.Command, proc.Time, proc.State, info,
+		)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+}
+
+// runTop periodically collects status of all the configured
+// instances and renders them to the terminal until the context
+// is cancelled. For instances with the SQLite sink configured,
+// a summary of the last historyPeriod is shown as well (unless
+// historyPeriod is zero).
+func runTop(
+	ctx context.Context,
+	confs []*cnf.Conf,
+	interval time.Duration,
+	numProcesses int,
+	historyPeriod time.Duration,
+	w io.Writer,
+) error {
+	targets := make([]*topTarget, 0, len(confs))
+	for _, conf := range confs {
+		conn, err := db.OpenDB(conf.DB)
+		if err != nil {
+			return fmt.Errorf("failed to open database %s: %w", conf.DB.Address(), err)
+		}
+		defer conn.Close()
+		env := &collector.Env{
+			DB:              conn,
+			InstanceName:    conf.InstanceName,
+			DefaultInterval: interval,
+		}
+		target := &topTarget{conf: conf, conn: conn}
+		if historyPeriod > 0 && conf.Sinks.SQLite != nil {
+			// the database may not exist yet (e.g. the daemon
+			// has not been started) which is not fatal here
+			target.history, target.historyErr = sqlite.OpenReadOnly(conf.Sinks.SQLite.Path)
+			if target.history != nil {
+				defer target.history.Close()
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(conf.Collectors)) {
+			coll, err := collector.New(name, env, conf.Collectors[name])
+			if err != nil {
+				return fmt.Errorf("failed to create collector %s: %w", name, err)
+			}
+			target.collectors = append(target.collectors, coll)
+		}
+		targets = append(targets, target)
+	}
+	refreshTimeout := min(interval, topMaxRefreshTimeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var out strings.Builder
+		out.WriteString(ansiClearScreen)
+		fmt.Fprintf(
+			&out, "MariaDB-TSCL top - %s (refresh every %s, Ctrl+C to quit)\n\n",
+			time.Now().Format(time.DateTime), interval,
+		)
+		for _, target := range targets {
+			refreshCtx, cancel := context.WithTimeout(ctx, refreshTimeout)
+			snap := target.collect(refreshCtx, numProcesses, historyPeriod)
+			cancel()
+			renderTopSnapshot(&out, snap)
+		}
+		if _, err := io.WriteString(w, out.String()); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}